@@ -1,14 +1,24 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"os"
 	"text/tabwriter"
 
+	"golang.org/x/net/context"
+
 	"github.com/coreos/updateservicectl/client/update/v1"
 )
 
 var (
+	adminFlags struct {
+		yes     bool
+		keyFile string
+	}
+
 	cmdAdminUser = &Command{
 		Name:    "admin-user",
 		Usage:   "",
@@ -17,12 +27,13 @@ var (
 			cmdAdminUserCreate,
 			cmdAdminUserList,
 			cmdAdminUserDelete,
+			cmdAdminUserRotateKey,
 		},
 	}
 	cmdAdminUserCreate = &Command{
 		Name:        "admin-user create",
 		Usage:       "<username>",
-		Description: "Creates an admin user.",
+		Description: "Creates an admin user and prints the key the server generated for it.",
 		Run:         adminUserCreate,
 	}
 	cmdAdminUserList = &Command{
@@ -32,13 +43,26 @@ var (
 	}
 	cmdAdminUserDelete = &Command{
 		Name:        "admin-user delete",
-		Usage:       "<username>",
-		Description: "Deletes an admin user.",
+		Usage:       "[OPTION]... <username>",
+		Description: "Deletes an admin user. Asks for confirmation unless --yes is given.",
 		Run:         adminUserDelete,
 	}
+	cmdAdminUserRotateKey = &Command{
+		Name:  "admin-user rotate-key",
+		Usage: "[OPTION]... <username>",
+		Description: `Replaces a user's key with a newly generated one and prints it. The old key
+stops working. Asks for confirmation unless --yes is given.`,
+		Run: adminUserRotateKey,
+	}
 )
 
-func adminUserCreate(args []string, service *update.Service, out *tabwriter.Writer) int {
+func init() {
+	cmdAdminUserDelete.Flags.BoolVar(&adminFlags.yes, "yes", false, "Delete without asking for confirmation.")
+	cmdAdminUserRotateKey.Flags.BoolVar(&adminFlags.yes, "yes", false, "Rotate without asking for confirmation.")
+	cmdAdminUserRotateKey.Flags.StringVar(&adminFlags.keyFile, "key-file", "", "Also write the new key to this file, readable only by its owner.")
+}
+
+func adminUserCreate(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if len(args) != 1 {
 		return ERROR_USAGE
 	}
@@ -46,39 +70,139 @@ func adminUserCreate(args []string, service *update.Service, out *tabwriter.Writ
 	req := &update.AdminUserReq{
 		UserName: args[0],
 	}
+	if dryRun("POST", apiURL(service.BasePath, "admin", "user"), req) {
+		return OK
+	}
 	call := service.Admin.CreateUser(req)
-	u, err := call.Do()
+	u, err := call.Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		// e.g. the user already exists
+		fatal(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Created user %s. Save the key below, it will not be shown again.\n", u.User)
+	if structuredOutput() {
+		return printStructured(out, u)
 	}
-	fmt.Println(u.Token)
+
+	fmt.Fprintln(out, u.Token)
+	out.Flush()
 	return OK
 }
 
-func adminUserDelete(args []string, service *update.Service, out *tabwriter.Writer) int {
+func adminUserDelete(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if len(args) != 1 {
 		return ERROR_USAGE
 	}
 	userName := args[0]
+	if dryRun("DELETE", apiURL(service.BasePath, "admin", "user", userName), nil) {
+		return OK
+	}
+
+	if userName == globalFlags.User {
+		fmt.Fprintf(os.Stderr, "WARNING: %s is the user this command authenticates as. Deleting it revokes your own access.\n", userName)
+	}
+	if !confirmDestructiveName(ctx, adminFlags.yes, fmt.Sprintf("Delete user %s?", userName), userName) {
+		return ERROR_ABORTED
+	}
+
 	call := service.Admin.DeleteUser(userName)
-	u, err := call.Do()
+	u, err := call.Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+	if structuredOutput() {
+		return printStructured(out, u)
 	}
-	fmt.Printf("User %s deleted\n", u.User)
+
+	fmt.Fprintf(out, "User %s deleted\n", u.User)
+	out.Flush()
 	return OK
 }
 
-func adminUserList(args []string, service *update.Service, out *tabwriter.Writer) int {
+func adminUserRotateKey(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if len(args) != 1 {
+		return ERROR_USAGE
+	}
+	userName := args[0]
+
+	req := &update.AdminUserReq{
+		UserName: userName,
+	}
+	if dryRun("PUT", apiURL(service.BasePath, "admin", "user", userName, "token", "new"), req) {
+		return OK
+	}
+
+	if !confirmDestructive(ctx, adminFlags.yes, fmt.Sprintf("Replace the key of %s? The current key will stop working.", userName)) {
+		return ERROR_ABORTED
+	}
+
+	call := service.Admin.GenToken(userName, req)
+	u, err := call.Context(ctx).Do()
+	if err != nil {
+		fatal(err)
+	}
+
+	if adminFlags.keyFile != "" {
+		if err := ioutil.WriteFile(adminFlags.keyFile, []byte(u.Token+"\n"), 0600); err != nil {
+			// the key has been replaced already, so don't lose it
+			fmt.Fprintln(os.Stderr, redact(errorMessage(err)))
+			fmt.Fprintln(out, u.Token)
+			out.Flush()
+			return ERROR_USAGE
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Rotated the key of %s. Save the key below, it will not be shown again.\n", u.User)
+	if structuredOutput() {
+		return printStructured(out, u)
+	}
+
+	fmt.Fprintln(out, u.Token)
+	out.Flush()
+	return OK
+}
+
+func adminUserList(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	call := service.Admin.ListUsers()
-	resp, err := call.Do()
+	resp, err := call.Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
+	// Keys are never printed, only a fingerprint to tell them apart.
+	users := make([]adminUserInfo, 0, len(resp.Users))
 	for _, u := range resp.Users {
-		fmt.Fprintf(out, "%s\n", u.User)
+		users = append(users, adminUserInfo{User: u.User, KeyFingerprint: keyFingerprint(u.Token)})
+	}
+
+	if structuredOutput() {
+		return printStructured(out, users)
+	}
+
+	if globalFlags.Quiet {
+		return printIDs(out, users, "User")
+	}
+
+	printHeader(out, "User\tKey Fingerprint\n")
+	for _, u := range users {
+		fmt.Fprintf(out, "%s\t%s\n", u.User, u.KeyFingerprint)
 	}
 	out.Flush()
 	return OK
 }
+
+type adminUserInfo struct {
+	User           string `json:"user"`
+	KeyFingerprint string `json:"keyFingerprint,omitempty"`
+}
+
+// keyFingerprint identifies an API key without revealing it, using a
+// truncated SHA-256 of the key.
+func keyFingerprint(key string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "SHA256:" + hex.EncodeToString(sum[:8])
+}
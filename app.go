@@ -2,19 +2,21 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"text/tabwriter"
 
 	"github.com/pborman/uuid"
+	"golang.org/x/net/context"
 
 	"github.com/coreos/updateservicectl/client/update/v1"
 )
 
 var (
 	appFlags struct {
-		appId       StringFlag
+		appId       UUIDFlag
 		label       StringFlag
 		description StringFlag
+		yes         bool
+		count       bool
 	}
 
 	cmdApp = &Command{
@@ -23,6 +25,7 @@ var (
 		Subcommands: []*Command{
 			cmdAppCreate,
 			cmdAppList,
+			cmdAppGet,
 			cmdAppUpdate,
 			cmdAppDelete,
 		},
@@ -38,6 +41,12 @@ var (
 		Description: `List all of the apps that exist including their label, token and update state.`,
 		Run:         appList,
 	}
+	cmdAppGet = &Command{
+		Name:        "app get",
+		Usage:       "[OPTION]...",
+		Description: `Show an app with its channels, groups and the number of instances on each.`,
+		Run:         appGet,
+	}
 	cmdAppUpdate = &Command{
 		Name:        "app update",
 		Usage:       "[OPTION]...",
@@ -47,21 +56,28 @@ var (
 	cmdAppDelete = &Command{
 		Name:        "app delete",
 		Usage:       "[OPTION]...",
-		Description: `Delete an app.`,
+		Description: `Delete an app. Asks for confirmation unless --yes is given.`,
 		Run:         appDelete,
 	}
 )
 
 func init() {
+	cmdAppList.Flags.BoolVar(&appFlags.count, "count", false, "Only print the number of applications.")
+
 	cmdAppCreate.Flags.Var(&appFlags.appId, "app-id", "Application UUID. If not provided, one will be randomly generated.")
 	cmdAppCreate.Flags.Var(&appFlags.label, "label", "New application label.")
 	cmdAppCreate.Flags.Var(&appFlags.description, "description", "New application description.")
 
+	cmdAppGet.Flags.Var(&appFlags.appId, "app-id", "Application ID to show.")
+
 	cmdAppUpdate.Flags.Var(&appFlags.appId, "app-id", "Application ID to update.")
-	cmdAppUpdate.Flags.Var(&appFlags.label, "label", "Set application label.")
-	cmdAppUpdate.Flags.Var(&appFlags.description, "description", "Set application description.")
+	cmdAppUpdate.Flags.Var(&appFlags.label, "label", "Set application label. Left unchanged if not given.")
+	cmdAppUpdate.Flags.Var(&appFlags.description, "description", "Set application description. Left unchanged if not given.")
 
 	cmdAppDelete.Flags.Var(&appFlags.appId, "app-id", "Application ID to delete.")
+	cmdAppDelete.Flags.BoolVar(&appFlags.yes, "yes", false, "Delete without asking for confirmation.")
+
+	addAppLabelFlag(&appFlags.appId, cmdAppGet, cmdAppUpdate, cmdAppDelete)
 }
 
 const appHeader = "Id\tLabel\tDescription\n"
@@ -70,15 +86,27 @@ func formatApp(app *update.App) string {
 	return fmt.Sprintf("%s\t%s\t%s\n", app.Id, app.Label, app.Description)
 }
 
-func appList(args []string, service *update.Service, out *tabwriter.Writer) int {
+func appList(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	listCall := service.App.List()
-	list, err := listCall.Do()
+	list, err := listCall.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if appFlags.count {
+		return printCount(out, list.Items)
 	}
 
-	fmt.Fprint(out, appHeader)
+	if structuredOutput() {
+		return printStructured(out, list.Items)
+	}
+
+	if globalFlags.Quiet {
+		return printIDs(out, list.Items, "Id")
+	}
+
+	printHeader(out, appHeader)
 	for _, app := range list.Items {
 		fmt.Fprintf(out, "%s", formatApp(app))
 	}
@@ -87,7 +115,82 @@ func appList(args []string, service *update.Service, out *tabwriter.Writer) int
 	return OK
 }
 
-func appCreate(args []string, service *update.Service, out *tabwriter.Writer) int {
+// appDetails is what app get shows. Instance counts are keyed by channel
+// and group ID.
+type appDetails struct {
+	App                *update.App          `json:"app"`
+	Channels           []*update.AppChannel `json:"channels"`
+	Groups             []*update.Group      `json:"groups"`
+	InstancesByChannel map[string]int       `json:"instancesByChannel"`
+	InstancesByGroup   map[string]int       `json:"instancesByGroup"`
+}
+
+func appGet(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if appFlags.appId.Get() == nil {
+		return ERROR_USAGE
+	}
+	appId := appFlags.appId.String()
+
+	app, err := service.App.Get(appId).Context(ctx).Do()
+	if err != nil {
+		fatal(err)
+	}
+	channels, err := service.Channel.List(appId).Context(ctx).Do()
+	if err != nil {
+		fatal(err)
+	}
+	groups, err := service.Group.List(appId).Context(ctx).Do()
+	if err != nil {
+		fatal(err)
+	}
+
+	// Instances report their group, not their channel, so channels are
+	// counted through the groups following them.
+	byGroup, _, err := countInstances(ctx, service, appId, "", func(cl *update.ClientUpdate) string { return cl.GroupId })
+	if err != nil {
+		fatal(err)
+	}
+	byChannel := make(map[string]int)
+	for _, group := range groups.Items {
+		byChannel[group.ChannelId] += byGroup[group.Id]
+	}
+
+	details := &appDetails{
+		App:                app,
+		Channels:           channels.Items,
+		Groups:             groups.Items,
+		InstancesByChannel: byChannel,
+		InstancesByGroup:   byGroup,
+	}
+	if output == outputEnv {
+		// the app itself is the single object; its channels and groups
+		// can't be written as variables
+		return printStructured(out, app)
+	}
+	if structuredOutput() {
+		return printStructured(out, details)
+	}
+
+	printHeader(out, appHeader)
+	fmt.Fprintf(out, "%s", formatApp(app))
+
+	fmt.Fprintln(out)
+	printHeader(out, "Channel\tVersion\tPublished\tInstances\n")
+	for _, channel := range channels.Items {
+		fmt.Fprintf(out, "%s\t%s\t%t\t%d\n", channel.Label, channel.Version, channel.Publish, byChannel[channel.Label])
+	}
+
+	fmt.Fprintln(out)
+	printHeader(out, "Group\tLabel\tChannel\tUpdates Paused\tPercent\tInstances\n")
+	for _, group := range groups.Items {
+		fmt.Fprintf(out, "%s\t%s\t%s\t%t\t%v\t%d\n", group.Id, group.Label, group.ChannelId, group.UpdatesPaused, group.UpdatePercent, byGroup[group.Id])
+	}
+
+	out.Flush()
+	return OK
+}
+
+func appCreate(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if appFlags.appId.Get() == nil {
 		appFlags.appId.Set(uuid.New())
 	}
@@ -97,14 +200,21 @@ func appCreate(args []string, service *update.Service, out *tabwriter.Writer) in
 		Label:       appFlags.label.String(),
 		Description: appFlags.description.String(),
 	}
+	if dryRun("POST", apiURL(service.BasePath, "apps"), appReq) {
+		return OK
+	}
 	call := service.App.Insert(appReq)
-	app, err := call.Do()
+	app, err := call.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, app)
 	}
 
-	fmt.Fprint(out, appHeader)
+	printHeader(out, appHeader)
 	fmt.Fprintf(out, "%s", formatApp(app))
 
 	out.Flush()
@@ -112,20 +222,44 @@ func appCreate(args []string, service *update.Service, out *tabwriter.Writer) in
 
 }
 
-func appUpdate(args []string, service *update.Service, out *tabwriter.Writer) int {
-	if appFlags.appId.Get() == nil || appFlags.label.Get() == nil || appFlags.description.Get() == nil {
+func appUpdate(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if appFlags.appId.Get() == nil || (appFlags.label.Get() == nil && appFlags.description.Get() == nil) {
 		return ERROR_USAGE
 	}
 
-	appReq := &update.AppUpdateReq{Label: appFlags.label.String(), Description: appFlags.description.String()}
+	// Start from the current app so flags that weren't given keep their
+	// values. Fields are always sent, since clearing one is a valid update.
+	current, err := service.App.Get(appFlags.appId.String()).Context(ctx).Do()
+	if err != nil {
+		fatal(err)
+	}
+
+	appReq := &update.AppUpdateReq{
+		Label:           current.Label,
+		Description:     current.Description,
+		ForceSendFields: []string{"Label", "Description"},
+	}
+	if appFlags.label.Get() != nil {
+		appReq.Label = appFlags.label.String()
+	}
+	if appFlags.description.Get() != nil {
+		appReq.Description = appFlags.description.String()
+	}
+	if dryRun("PATCH", apiURL(service.BasePath, "apps", appFlags.appId.String()), appReq) {
+		return OK
+	}
 	call := service.App.Update(appFlags.appId.String(), appReq)
-	app, err := call.Do()
+	app, err := call.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	fmt.Fprint(out, appHeader)
+	if structuredOutput() {
+		return printStructured(out, app)
+	}
+
+	printHeader(out, appHeader)
 	fmt.Fprintf(out, "%s", formatApp(app))
 
 	out.Flush()
@@ -133,19 +267,31 @@ func appUpdate(args []string, service *update.Service, out *tabwriter.Writer) in
 
 }
 
-func appDelete(args []string, service *update.Service, out *tabwriter.Writer) int {
+func appDelete(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if appFlags.appId.Get() == nil {
 		return ERROR_USAGE
 	}
 
+	if dryRun("DELETE", apiURL(service.BasePath, "apps", appFlags.appId.String()), nil) {
+		return OK
+	}
+
+	if !confirmDestructiveName(ctx, appFlags.yes, fmt.Sprintf("Delete app %s?", appFlags.appId.String()), appFlags.appId.String()) {
+		return ERROR_ABORTED
+	}
+
 	call := service.App.Delete(appFlags.appId.String())
-	app, err := call.Do()
+	app, err := call.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, app)
 	}
 
-	fmt.Fprint(out, appHeader)
+	printHeader(out, appHeader)
 	fmt.Fprintf(out, "%s", formatApp(app))
 
 	out.Flush()
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+// appLabelFlag is the --app-label of a command, resolved to the command's
+// --app-id before it runs.
+type appLabelFlag struct {
+	label string
+	appId *UUIDFlag
+}
+
+// appLabelFlags maps the flag sets of commands taking --app-label to it.
+var appLabelFlags = make(map[*flag.FlagSet]*appLabelFlag)
+
+// addAppLabelFlag adds --app-label to cmds as an alternative to the --app-id
+// stored in appId.
+func addAppLabelFlag(appId *UUIDFlag, cmds ...*Command) {
+	for _, cmd := range cmds {
+		l := &appLabelFlag{appId: appId}
+		cmd.Flags.StringVar(&l.label, "app-label", "", "Label of the application, looked up instead of giving --app-id.")
+		appLabelFlags[&cmd.Flags] = l
+	}
+}
+
+// appLabelGiven reports whether --app-label stands in for --app-id in fs.
+func appLabelGiven(fs *flag.FlagSet) bool {
+	l, ok := appLabelFlags[fs]
+	return ok && l.label != ""
+}
+
+// resolveAppLabel sets the --app-id of fs to the application labeled with
+// its --app-label, if one was given.
+func resolveAppLabel(ctx context.Context, service *update.Service, fs *flag.FlagSet) int {
+	if !appLabelGiven(fs) {
+		return OK
+	}
+	l := appLabelFlags[fs]
+	if l.appId.Get() != nil {
+		fmt.Fprintln(os.Stderr, "--app-id and --app-label can't be used together")
+		return ERROR_USAGE
+	}
+
+	matches := appsLabeled(ctx, service, l.label)
+
+	switch len(matches) {
+	case 0:
+		fmt.Fprintf(os.Stderr, "no application labeled %q\n", l.label)
+		return ERROR_NOT_FOUND
+	case 1:
+		if err := l.appId.Set(matches[0].Id); err != nil {
+			fmt.Fprintf(os.Stderr, "application %q has an invalid ID %q\n", l.label, matches[0].Id)
+			return ERROR_API
+		}
+		return OK
+	}
+	fmt.Fprintf(os.Stderr, "%d applications are labeled %q, use --app-id with one of:\n", len(matches), l.label)
+	for _, app := range matches {
+		fmt.Fprintf(os.Stderr, "\t%s\t%s\n", app.Id, app.Description)
+	}
+	return ERROR_USAGE
+}
+
+// appsLabeled returns the applications labeled label. With --cache-ttl the
+// app list is kept in a cache file, which is only refreshed once it expires
+// or has no app of that label, so that scripts running many commands don't
+// each fetch it.
+func appsLabeled(ctx context.Context, service *update.Service, label string) []*update.App {
+	ttl := globalFlags.CacheTTL
+	useCache := (ttl > 0 || globalFlags.RefreshCache) && !globalFlags.NoCache
+
+	var cache appCache
+	if useCache {
+		cache = readAppCache()
+		if e, ok := cache[globalFlags.Server]; ok && !globalFlags.RefreshCache && time.Since(e.Fetched) < ttl {
+			if matches := filterAppsByLabel(e.Apps, label); len(matches) > 0 {
+				return matches
+			}
+		}
+	}
+
+	apps, err := listAppsByID(ctx, service)
+	if err != nil {
+		fatal(err)
+	}
+	if useCache {
+		cache[globalFlags.Server] = appCacheEntry{Fetched: time.Now(), Apps: apps}
+		if err := writeAppCache(cache); err != nil {
+			log.Printf("warning: writing the app cache failed (%v)\n", err)
+		}
+	}
+	return filterAppsByLabel(apps, label)
+}
+
+func filterAppsByLabel(apps []*update.App, label string) []*update.App {
+	var matches []*update.App
+	for _, app := range apps {
+		if app.Label == label {
+			matches = append(matches, app)
+		}
+	}
+	return matches
+}
+
+// appCache is the contents of the app cache file, keyed by server URL.
+type appCache map[string]appCacheEntry
+
+type appCacheEntry struct {
+	Fetched time.Time     `json:"fetched"`
+	Apps    []*update.App `json:"apps"`
+}
+
+func appCachePath() string {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".cache")
+	}
+	return filepath.Join(dir, "updatectl", "apps.json")
+}
+
+// readAppCache returns the cached app lists. A missing or unreadable cache
+// is treated as empty.
+func readAppCache() appCache {
+	cache := make(appCache)
+	b, err := ioutil.ReadFile(appCachePath())
+	if err != nil || json.Unmarshal(b, &cache) != nil {
+		return make(appCache)
+	}
+	return cache
+}
+
+func writeAppCache(cache appCache) error {
+	path := appCachePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	// Write a temporary file and rename it, so concurrent runs never read
+	// a partial cache.
+	tmp := path + fmt.Sprintf(".%d", os.Getpid())
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
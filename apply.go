@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+var (
+	applyFlags struct {
+		file  string
+		appId UUIDFlag
+		all   bool
+	}
+
+	cmdApply = &Command{
+		Name:    "apply",
+		Usage:   "-f FILE",
+		Summary: "Create or update apps, channels and groups to match a spec file.",
+		Description: `Reads a JSON spec listing apps with their channels and groups, and
+creates or updates each so it matches. Fields left out of the spec are
+left unchanged on the server, so applying the same spec again changes
+nothing. With --dry-run the requests are printed instead of sent.
+
+	{"apps": [{"id": "<uuid>", "label": "CoreOS",
+	  "channels": [{"label": "stable", "version": "1.2.3", "publish": true}],
+	  "groups": [{"id": "stable", "label": "Stable", "channel": "stable",
+	              "updatesPaused": false, "updatePercent": 100}]}]}`,
+		Run: apply,
+	}
+
+	cmdExport = &Command{
+		Name:    "export",
+		Usage:   "[OPTION]...",
+		Summary: "Write apps with their channels and groups as a spec for apply.",
+		Description: `Writes the given app, or every app with --all, in the spec format apply
+reads. The output is always JSON, the only format apply reads.`,
+		Run: export,
+	}
+)
+
+func init() {
+	cmdApply.Flags.StringVar(&applyFlags.file, "f", "", "Spec file to apply, - for stdin.")
+
+	cmdExport.Flags.Var(&applyFlags.appId, "app-id", "Application to export.")
+	cmdExport.Flags.BoolVar(&applyFlags.all, "all", false, "Export every application.")
+}
+
+// applySpec is the format apply reads and export writes. The pointer fields
+// of the spec types are optional; export always sets them.
+type applySpec struct {
+	Apps []applyApp `json:"apps"`
+}
+
+type applyApp struct {
+	Id          string         `json:"id"`
+	Label       *string        `json:"label"`
+	Description *string        `json:"description"`
+	Channels    []applyChannel `json:"channels"`
+	Groups      []applyGroup   `json:"groups"`
+}
+
+type applyChannel struct {
+	Label   string  `json:"label"`
+	Version *string `json:"version"`
+	Publish *bool   `json:"publish"`
+}
+
+type applyGroup struct {
+	Id            string   `json:"id"`
+	Label         *string  `json:"label"`
+	Channel       *string  `json:"channel"`
+	UpdatesPaused *bool    `json:"updatesPaused"`
+	UpdatePercent *float64 `json:"updatePercent"`
+	OemBlacklist  *string  `json:"oemBlacklist"`
+}
+
+const (
+	applyCreated   = "created"
+	applyUpdated   = "updated"
+	applyUnchanged = "unchanged"
+)
+
+// applyResult is what apply did to one resource.
+type applyResult struct {
+	App    string `json:"app"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+func apply(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if applyFlags.file == "" {
+		return ERROR_USAGE
+	}
+	spec, err := readApplySpec(applyFlags.file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ERROR_USAGE
+	}
+
+	var results []applyResult
+	for _, app := range spec.Apps {
+		results = append(results, applyAppSpec(ctx, service, app)...)
+	}
+
+	if globalFlags.DryRun {
+		for i := range results {
+			if results[i].Status != applyUnchanged {
+				results[i].Status = "would be " + results[i].Status
+			}
+		}
+	}
+
+	if structuredOutput() {
+		return printStructured(out, results)
+	}
+
+	printHeader(out, "App\tKind\tName\tStatus\n")
+	for _, r := range results {
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", r.App, r.Kind, r.Name, r.Status)
+	}
+	out.Flush()
+	return OK
+}
+
+func export(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if (applyFlags.appId.Get() == nil) == !applyFlags.all {
+		fmt.Fprintln(os.Stderr, "either --app-id or --all is required")
+		return ERROR_USAGE
+	}
+	if output != outputTable && output != outputJSON {
+		fmt.Fprintf(os.Stderr, "export writes JSON, the only format apply reads; --output %s isn't supported\n", output)
+		return ERROR_USAGE
+	}
+
+	var apps []*update.App
+	if applyFlags.all {
+		var err error
+		if apps, err = listAppsByID(ctx, service); err != nil {
+			fatal(err)
+		}
+	} else {
+		app, err := service.App.Get(applyFlags.appId.String()).Context(ctx).Do()
+		if err != nil {
+			fatal(err)
+		}
+		apps = []*update.App{app}
+	}
+
+	spec := applySpec{Apps: make([]applyApp, len(apps))}
+	err := parallel(ctx, len(apps), func(ctx context.Context, i int) error {
+		app, err := exportApp(ctx, service, apps[i])
+		spec.Apps[i] = app
+		return err
+	})
+	if err != nil {
+		fatal(err)
+	}
+
+	output = outputJSON
+	return printStructured(out, spec)
+}
+
+func exportApp(ctx context.Context, service *update.Service, app *update.App) (applyApp, error) {
+	spec := applyApp{
+		Id:          app.Id,
+		Label:       &app.Label,
+		Description: &app.Description,
+		Channels:    []applyChannel{},
+		Groups:      []applyGroup{},
+	}
+
+	channels, err := service.Channel.List(app.Id).Context(ctx).Do()
+	if err != nil {
+		return spec, err
+	}
+	for _, c := range channels.Items {
+		spec.Channels = append(spec.Channels, applyChannel{
+			Label:   c.Label,
+			Version: &c.Version,
+			Publish: &c.Publish,
+		})
+	}
+
+	groups, err := service.Group.List(app.Id).Context(ctx).Do()
+	if err != nil {
+		return spec, err
+	}
+	for _, g := range groups.Items {
+		spec.Groups = append(spec.Groups, applyGroup{
+			Id:            g.Id,
+			Label:         &g.Label,
+			Channel:       &g.ChannelId,
+			UpdatesPaused: &g.UpdatesPaused,
+			UpdatePercent: &g.UpdatePercent,
+			OemBlacklist:  &g.OemBlacklist,
+		})
+	}
+	return spec, nil
+}
+
+func readApplySpec(path string) (*applySpec, error) {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+	}
+
+	var spec applySpec
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, app := range spec.Apps {
+		if app.Id == "" {
+			return nil, fmt.Errorf("%s: every app needs an id", path)
+		}
+		for _, c := range app.Channels {
+			if c.Label == "" {
+				return nil, fmt.Errorf("%s: every channel of app %s needs a label", path, app.Id)
+			}
+		}
+		for _, g := range app.Groups {
+			if g.Id == "" {
+				return nil, fmt.Errorf("%s: every group of app %s needs an id", path, app.Id)
+			}
+		}
+	}
+	return &spec, nil
+}
+
+// applyAppSpec applies one app of the spec, then its channels and then its
+// groups, since groups refer to channels.
+func applyAppSpec(ctx context.Context, service *update.Service, spec applyApp) []applyResult {
+	result := func(kind, name, status string) applyResult {
+		return applyResult{App: spec.Id, Kind: kind, Name: name, Status: status}
+	}
+
+	status := applyUnchanged
+	current, err := service.App.Get(spec.Id).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound:
+		req := &update.AppInsertReq{Id: spec.Id}
+		if spec.Label != nil {
+			req.Label = *spec.Label
+		}
+		if spec.Description != nil {
+			req.Description = *spec.Description
+		}
+		if !dryRun("POST", apiURL(service.BasePath, "apps"), req) {
+			if _, err := service.App.Insert(req).Context(ctx).Do(); err != nil {
+				fatal(err)
+			}
+		}
+		status = applyCreated
+	case err != nil:
+		fatal(err)
+	default:
+		req := &update.AppUpdateReq{
+			Label:           current.Label,
+			Description:     current.Description,
+			ForceSendFields: []string{"Label", "Description"},
+		}
+		if spec.Label != nil {
+			req.Label = *spec.Label
+		}
+		if spec.Description != nil {
+			req.Description = *spec.Description
+		}
+		if req.Label != current.Label || req.Description != current.Description {
+			if !dryRun("PATCH", apiURL(service.BasePath, "apps", spec.Id), req) {
+				if _, err := service.App.Update(spec.Id, req).Context(ctx).Do(); err != nil {
+					fatal(err)
+				}
+			}
+			status = applyUpdated
+		}
+	}
+	name := spec.Id
+	if spec.Label != nil {
+		name = *spec.Label
+	}
+	results := []applyResult{result("app", name, status)}
+
+	// A new app has no channels or groups yet, and in a dry run it doesn't
+	// exist to list them.
+	channels := make(map[string]*update.AppChannel)
+	groups := make(map[string]*update.Group)
+	if status != applyCreated {
+		list, err := service.Channel.List(spec.Id).Context(ctx).Do()
+		if err != nil {
+			fatal(err)
+		}
+		for _, c := range list.Items {
+			channels[c.Label] = c
+		}
+		groupList, err := service.Group.List(spec.Id).Context(ctx).Do()
+		if err != nil {
+			fatal(err)
+		}
+		for _, g := range groupList.Items {
+			groups[g.Id] = g
+		}
+	}
+
+	for _, c := range spec.Channels {
+		results = append(results, result("channel", c.Label, applyChannelSpec(ctx, service, spec.Id, c, channels[c.Label])))
+	}
+	for _, g := range spec.Groups {
+		results = append(results, result("group", g.Id, applyGroupSpec(ctx, service, spec.Id, g, groups[g.Id])))
+	}
+	return results
+}
+
+func applyChannelSpec(ctx context.Context, service *update.Service, appId string, spec applyChannel, current *update.AppChannel) string {
+	req := &update.ChannelRequest{AppId: appId, Label: spec.Label, ForceSendFields: []string{"Publish"}}
+	if current != nil {
+		req.Version, req.Publish = current.Version, current.Publish
+	}
+	if spec.Version != nil {
+		req.Version = *spec.Version
+	}
+	if spec.Publish != nil {
+		req.Publish = *spec.Publish
+	}
+
+	if current == nil {
+		if !dryRun("POST", apiURL(service.BasePath, "apps", appId, "channels"), req) {
+			if _, err := service.Channel.Insert(appId, req).Context(ctx).Do(); err != nil {
+				fatal(err)
+			}
+		}
+		return applyCreated
+	}
+	if req.Version == current.Version && req.Publish == current.Publish {
+		return applyUnchanged
+	}
+	if !dryRun("PATCH", apiURL(service.BasePath, "apps", appId, "channels", spec.Label), req) {
+		if _, err := service.Channel.Update(appId, spec.Label, req).Context(ctx).Do(); err != nil {
+			fatal(err)
+		}
+	}
+	return applyUpdated
+}
+
+func applyGroupSpec(ctx context.Context, service *update.Service, appId string, spec applyGroup, current *update.Group) string {
+	group := &update.Group{AppId: appId, Id: spec.Id}
+	if current != nil {
+		g := *current
+		group = &g
+	}
+	if spec.Label != nil {
+		group.Label = *spec.Label
+	}
+	if spec.Channel != nil {
+		group.ChannelId = *spec.Channel
+	}
+	if spec.UpdatesPaused != nil {
+		group.UpdatesPaused = *spec.UpdatesPaused
+	}
+	if spec.UpdatePercent != nil {
+		group.UpdatePercent = *spec.UpdatePercent
+	}
+	if spec.OemBlacklist != nil {
+		group.OemBlacklist = *spec.OemBlacklist
+	}
+	// Always send the fields that can be set to their empty value.
+	group.ForceSendFields = []string{"UpdatesPaused", "UpdatePercent", "OemBlacklist"}
+
+	if current == nil {
+		if !dryRun("POST", apiURL(service.BasePath, "apps", appId, "groups"), group) {
+			if _, err := service.Group.Insert(appId, group).Context(ctx).Do(); err != nil {
+				fatal(err)
+			}
+		}
+		return applyCreated
+	}
+	if group.Label == current.Label && group.ChannelId == current.ChannelId &&
+		group.UpdatesPaused == current.UpdatesPaused && group.UpdatePercent == current.UpdatePercent &&
+		group.OemBlacklist == current.OemBlacklist {
+		return applyUnchanged
+	}
+	if !dryRun("PATCH", apiURL(service.BasePath, "apps", appId, "groups", spec.Id), group) {
+		if _, err := service.Group.Patch(appId, spec.Id, group).Context(ctx).Do(); err != nil {
+			fatal(err)
+		}
+	}
+	return applyUpdated
+}
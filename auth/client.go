@@ -1,33 +1,108 @@
 package auth
 
 import (
+	"bytes"
+	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/tls"
+	"hash"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strings"
 
 	"github.com/coreos/hawk-go"
 )
 
 var DefaultHawkHasher = sha256.New
 
+// HawkAlgorithms are the MAC algorithms HawkRoundTripper can sign with, by
+// the names Hawk gives them.
+var HawkAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha1":   sha1.New,
+}
+
 type HawkRoundTripper struct {
 	User          string
 	Token         string
 	SkipSSLVerify bool
+
+	// Hash computes the MAC. If nil, DefaultHawkHasher is used.
+	Hash func() hash.Hash
+
+	// PayloadHash includes a hash of the body, for requests having one, in
+	// the signature.
+	PayloadHash bool
+
+	// Transport is used to send signed requests. If nil, a transport
+	// honoring SkipSSLVerify is created for each request.
+	Transport http.RoundTripper
 }
 
 func (t *HawkRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	hasher := t.Hash
+	if hasher == nil {
+		hasher = DefaultHawkHasher
+	}
 	creds := &hawk.Credentials{
 		ID:   t.User,
 		Key:  t.Token,
-		Hash: DefaultHawkHasher,
+		Hash: hasher,
 	}
 
+	// Sign a copy, leaving the caller's request, which may end up in logs or
+	// error messages, without the Authorization header.
+	req = req.Clone(req.Context())
 	auth := hawk.NewRequestAuth(req, creds, 0)
+	if t.PayloadHash {
+		if err := setPayloadHash(auth, req); err != nil {
+			return nil, err
+		}
+	}
 	req.Header.Set("Authorization", auth.RequestHeader())
 
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: t.SkipSSLVerify},
+	transport := t.Transport
+	if transport == nil {
+		transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: t.SkipSSLVerify},
+		}
 	}
 	return transport.RoundTrip(req)
 }
+
+// setPayloadHash hashes the body of req into auth and replaces the body with
+// a buffered copy. The body is read from GetBody when possible, and the
+// original is closed, as the transport is responsible for closing it.
+func setPayloadHash(auth *hawk.Auth, req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+
+	var body io.ReadCloser = req.Body
+	if req.GetBody != nil {
+		var err error
+		if body, err = req.GetBody(); err != nil {
+			return err
+		}
+	}
+	b, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+	if body != req.Body {
+		req.Body.Close()
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+
+	// Hawk hashes the media type alone, lower cased.
+	contentType := strings.SplitN(req.Header.Get("Content-Type"), ";", 2)[0]
+	h := auth.PayloadHash(strings.ToLower(strings.TrimSpace(contentType)))
+	h.Write(b)
+	auth.SetHash(h)
+	return nil
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type captureTransport struct {
+	req  *http.Request
+	body string
+}
+
+func (t *captureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.req, t.body = req, ""
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		t.body = string(b)
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+// The payload hash example from the Hawk specification.
+const (
+	hawkPayload     = "Thank you for flying Hawk"
+	hawkPayloadHash = `hash="Yi9LfIIFRtBEPt74PVmbTF/xVAwPn7ub15ePICfgnuY="`
+)
+
+func TestPayloadHash(t *testing.T) {
+	capture := &captureTransport{}
+	rt := &HawkRoundTripper{
+		User:        "dh37fgj492je",
+		Token:       "werxhqb98rpaxn39848xrunpaw3489ruxnpa98w4rxn",
+		PayloadHash: true,
+		Transport:   capture,
+	}
+
+	req, err := http.NewRequest("POST", "http://example.com:8000/resource/1?b=1&a=2", strings.NewReader(hawkPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "Text/Plain; charset=utf-8")
+
+	for attempt := 0; attempt < 2; attempt++ {
+		// a retry sends a fresh copy of the body, as retryRoundTripper does
+		if attempt > 0 {
+			req = req.Clone(req.Context())
+			if req.Body, err = req.GetBody(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := rt.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+
+		if h := capture.req.Header.Get("Authorization"); !strings.Contains(h, hawkPayloadHash) {
+			t.Errorf("attempt %d: Authorization %q lacks %s", attempt, h, hawkPayloadHash)
+		}
+		if capture.body != hawkPayload {
+			t.Errorf("attempt %d: sent body %q, want %q", attempt, capture.body, hawkPayload)
+		}
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Error("the caller's request was signed")
+	}
+}
+
+func TestPayloadHashWithoutBody(t *testing.T) {
+	capture := &captureTransport{}
+	rt := &HawkRoundTripper{User: "id", Token: "key", PayloadHash: true, Transport: capture}
+
+	req, err := http.NewRequest("GET", "http://example.com/resource", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if h := capture.req.Header.Get("Authorization"); strings.Contains(h, "hash=") {
+		t.Errorf("Authorization %q has a payload hash for a request without a body", h)
+	}
+}
+
+type closeBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *closeBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestPayloadHashClosesBody(t *testing.T) {
+	capture := &captureTransport{}
+	rt := &HawkRoundTripper{User: "id", Token: "key", PayloadHash: true, Transport: capture}
+
+	req, err := http.NewRequest("POST", "http://example.com/resource", strings.NewReader(hawkPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := &closeBody{Reader: strings.NewReader(hawkPayload)}
+	req.Body = body
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if !body.closed {
+		t.Error("the original request body wasn't closed")
+	}
+	if capture.body != hawkPayload {
+		t.Errorf("sent body %q, want %q", capture.body, hawkPayload)
+	}
+}
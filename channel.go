@@ -2,18 +2,26 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"os"
+	"sort"
 	"text/tabwriter"
+	"time"
+
+	"golang.org/x/net/context"
 
 	"github.com/coreos/updateservicectl/client/update/v1"
 )
 
 var (
 	channelFlags struct {
-		appId   StringFlag
+		appId   UUIDFlag
 		channel StringFlag
 		version StringFlag
 		publish bool
+		limit   int
+		yes     bool
+
+		updatePublish BoolFlag
 	}
 
 	cmdChannel = &Command{
@@ -24,6 +32,8 @@ var (
 			cmdChannelUpdate,
 			cmdChannelCreate,
 			cmdChannelDelete,
+			cmdChannelHistory,
+			cmdChannelRollback,
 		},
 	}
 
@@ -58,23 +68,59 @@ you can change the channel to a new version (--version), or set the publish stat
 		Description: `Deletes the channel with matching application ID (--app-id) and channel (--channel).`,
 		Run:         channelDelete,
 	}
+
+	cmdChannelHistory = &Command{
+		Name:    "channel history",
+		Usage:   "[OPTION]...",
+		Summary: `Show the versions an application channel has pointed at.`,
+		Description: `Lists the versions of the channel (--channel) of an application (--app-id), newest first.
+The roller doesn't record channel changes, so the timeline is derived from the instances
+of the groups following the channel: each version is dated by the earliest report of an
+instance running it. Versions no instance still reports are not shown.`,
+		Run: channelHistory,
+	}
+
+	cmdChannelRollback = &Command{
+		Name:    "channel rollback",
+		Usage:   "[OPTION]...",
+		Summary: `Point an application channel back at its previous version.`,
+		Description: `Sets the channel (--channel) of an application (--app-id) back to the version it
+pointed at before its current one, as shown by channel history. Asks for confirmation
+unless --yes is given.`,
+		Run: channelRollback,
+	}
 )
 
 func init() {
+	channelFlags.appId.required = true
+	channelFlags.channel.required = true
+
 	cmdChannelList.Flags.Var(&channelFlags.appId, "app-id", "The application ID to list the channels of.")
 
 	cmdChannelCreate.Flags.Var(&channelFlags.appId, "app-id", "The application ID that the channel belongs to.")
 	cmdChannelCreate.Flags.Var(&channelFlags.channel, "channel", "The channel to create.")
 	cmdChannelCreate.Flags.BoolVar(&channelFlags.publish, "publish", false, "Publish or unpublish the channel.")
-	cmdChannelCreate.Flags.Var(&channelFlags.version, "version", "The version to create the channel to.")
+	cmdChannelCreate.Flags.Var(&channelFlags.version, "version", "The version to initialize the channel to. Optional.")
 
 	cmdChannelUpdate.Flags.Var(&channelFlags.appId, "app-id", "The application ID that the channel belongs to.")
 	cmdChannelUpdate.Flags.Var(&channelFlags.channel, "channel", "The channel to update.")
-	cmdChannelUpdate.Flags.BoolVar(&channelFlags.publish, "publish", false, "Publish or unpublish the channel.")
+	cmdChannelUpdate.Flags.Var(&channelFlags.updatePublish, "publish", "Publish or unpublish the channel. Left unchanged if not given.")
 	cmdChannelUpdate.Flags.Var(&channelFlags.version, "version", "The version to update the channel to.")
+	addWaitFlags(&cmdChannelUpdate.Flags)
 
 	cmdChannelDelete.Flags.Var(&channelFlags.appId, "app-id", "The application ID that the channel belongs to.")
 	cmdChannelDelete.Flags.Var(&channelFlags.channel, "channel", "The channel to update.")
+
+	cmdChannelHistory.Flags.Var(&channelFlags.appId, "app-id", "The application ID that the channel belongs to.")
+	cmdChannelHistory.Flags.Var(&channelFlags.channel, "channel", "The channel to show the history of.")
+	cmdChannelHistory.Flags.IntVar(&channelFlags.limit, "limit", 0, "Show at most this many changes, 0 for all.")
+
+	cmdChannelRollback.Flags.Var(&channelFlags.appId, "app-id", "The application ID that the channel belongs to.")
+	cmdChannelRollback.Flags.Var(&channelFlags.channel, "channel", "The channel to roll back.")
+	cmdChannelRollback.Flags.BoolVar(&channelFlags.yes, "yes", false, "Roll back without asking for confirmation.")
+
+	addAppLabelFlag(&channelFlags.appId, cmdChannelList, cmdChannelCreate, cmdChannelUpdate,
+		cmdChannelDelete, cmdChannelHistory, cmdChannelRollback)
 }
 
 const channelHeader = "Label\tVersion\tPublish\tUpstream\n"
@@ -83,79 +129,347 @@ func formatChannel(channel *update.AppChannel) string {
 	return fmt.Sprintf("%s\t%s\t%t\t%s\n", channel.Label, channel.Version, channel.Publish, channel.Upstream)
 }
 
-func channelList(args []string, service *update.Service, out *tabwriter.Writer) int {
+func channelList(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if channelFlags.appId.Get() == nil {
 		return ERROR_USAGE
 	}
 
 	listCall := service.Channel.List(channelFlags.appId.String())
-	list, err := listCall.Do()
+	list, err := listCall.Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
-	fmt.Fprint(out, channelHeader)
-	for _, channel := range list.Items {
-		fmt.Fprintf(out, "%s", formatChannel(channel))
+
+	groups, err := service.Group.List(channelFlags.appId.String()).Context(ctx).Do()
+	if err != nil {
+		fatal(err)
+	}
+	subscribed := make(map[string]int)
+	for _, group := range groups.Items {
+		subscribed[group.ChannelId]++
+	}
+
+	if structuredOutput() {
+		items := make([]channelListItem, len(list.Items))
+		for i, c := range list.Items {
+			items[i] = channelListItem{c.AppId, c.Label, c.Version, c.Publish, c.Upstream, c.DateCreated, subscribed[c.Label]}
+		}
+		return printStructured(out, items)
+	}
+
+	if globalFlags.Quiet {
+		return printIDs(out, list.Items, "Label")
+	}
+
+	printHeader(out, "Label\tVersion\tPublish\tUpstream\tGroups\n")
+	for _, c := range list.Items {
+		fmt.Fprintf(out, "%s\t%s\t%t\t%s\t%d\n", c.Label, c.Version, c.Publish, c.Upstream, subscribed[c.Label])
 	}
 	out.Flush()
 	return OK
 }
 
-func channelCreate(args []string, service *update.Service, out *tabwriter.Writer) int {
-	if channelFlags.version.Get() == nil || channelFlags.appId.Get() == nil || channelFlags.channel.Get() == nil {
+// channelListItem is a channel as channel list serializes it, with the
+// number of groups following it.
+type channelListItem struct {
+	AppId       string `json:"appId,omitempty"`
+	Label       string `json:"label,omitempty"`
+	Version     string `json:"version,omitempty"`
+	Publish     bool   `json:"publish"`
+	Upstream    string `json:"upstream,omitempty"`
+	DateCreated string `json:"dateCreated,omitempty"`
+	Groups      int    `json:"groups"`
+}
+
+func channelCreate(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if channelFlags.appId.Get() == nil || channelFlags.channel.Get() == nil {
 		return ERROR_USAGE
 	}
 
 	channelReq := &update.ChannelRequest{
-		Version: *channelFlags.version.Get(),
+		Version: channelFlags.version.String(),
 		Publish: channelFlags.publish,
 		Label:   *channelFlags.channel.Get(),
 		AppId:   *channelFlags.appId.Get(),
 	}
 
-	call := service.Channel.Insert(*channelFlags.channel.Get(), channelReq)
-	channel, err := call.Do()
+	if dryRun("POST", apiURL(service.BasePath, "apps", channelFlags.appId.String(), "channels"), channelReq) {
+		return OK
+	}
+	call := service.Channel.Insert(channelFlags.appId.String(), channelReq)
+	channel, err := call.Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, channel)
 	}
 
-	fmt.Fprint(out, channelHeader)
+	printHeader(out, channelHeader)
 	fmt.Fprintf(out, "%s", formatChannel(channel))
 	out.Flush()
 	return OK
 }
 
-func channelUpdate(args []string, service *update.Service, out *tabwriter.Writer) int {
+func channelUpdate(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if channelFlags.version.Get() == nil || channelFlags.appId.Get() == nil || channelFlags.channel.Get() == nil {
 		return ERROR_USAGE
 	}
+	if !checkWaitFlags() {
+		return ERROR_USAGE
+	}
 
-	channelReq := &update.ChannelRequest{Version: *channelFlags.version.Get(), Publish: channelFlags.publish}
+	appId, label := channelFlags.appId.String(), channelFlags.channel.String()
+
+	var publish bool
+	if p := channelFlags.updatePublish.Get(); p != nil {
+		publish = *p
+	} else {
+		current, code := findChannel(ctx, service, appId, label)
+		if current == nil {
+			return code
+		}
+		publish = current.Publish
+	}
+
+	channelReq := &update.ChannelRequest{
+		Version:         *channelFlags.version.Get(),
+		Publish:         publish,
+		ForceSendFields: []string{"Publish"},
+	}
 
-	call := service.Channel.Update(channelFlags.appId.String(), channelFlags.channel.String(), channelReq)
-	channel, err := call.Do()
+	if dryRun("PATCH", apiURL(service.BasePath, "apps", appId, "channels", label), channelReq) {
+		return OK
+	}
+	call := service.Channel.Update(appId, label, channelReq)
+	channel, err := call.Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	fmt.Fprint(out, channelHeader)
-	fmt.Fprintf(out, "%s", formatChannel(channel))
-	out.Flush()
+	if structuredOutput() {
+		if code := printStructured(out, channel); code != OK {
+			return code
+		}
+	} else {
+		printHeader(out, channelHeader)
+		fmt.Fprintf(out, "%s", formatChannel(channel))
+		out.Flush()
+	}
+
+	if waitFlags.wait {
+		return waitForChannel(ctx, service, appId, label, channel.Version)
+	}
 	return OK
 }
 
-func channelDelete(args []string, service *update.Service, out *tabwriter.Writer) int {
+// waitForChannel implements --wait for a channel, waiting for the version in
+// every group that follows it.
+func waitForChannel(ctx context.Context, service *update.Service, appId, label, version string) int {
+	groups, err := service.Group.List(appId).Context(ctx).Do()
+	if err != nil {
+		fatal(err)
+	}
+	var groupIds []string
+	for _, group := range groups.Items {
+		if group.ChannelId == label {
+			groupIds = append(groupIds, group.Id)
+		}
+	}
+	if len(groupIds) == 0 {
+		fmt.Fprintf(os.Stderr, "no groups follow channel %s, not waiting\n", label)
+		return OK
+	}
+	return waitForRollout(ctx, service, appId, groupIds, version)
+}
+
+func channelDelete(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if channelFlags.appId.Get() == nil || channelFlags.channel.Get() == nil {
 		return ERROR_USAGE
 	}
 
+	if dryRun("DELETE", apiURL(service.BasePath, "apps", channelFlags.appId.String(), "channels", channelFlags.channel.String()), nil) {
+		return OK
+	}
 	call := service.Channel.Delete(channelFlags.appId.String(), channelFlags.channel.String())
-	_, err := call.Do()
+	channel, err := call.Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, channel)
 	}
 
 	fmt.Fprintf(out, "deleted channel: %s, for application: %s\n", channelFlags.channel.String(), channelFlags.appId.String())
 	out.Flush()
 	return OK
 }
+
+// channelChange is one step of a channel's version timeline.
+type channelChange struct {
+	Time       string `json:"time"`
+	OldVersion string `json:"oldVersion,omitempty"`
+	NewVersion string `json:"newVersion"`
+}
+
+// channelVersionHistory derives the timeline of a channel, newest first, from
+// the instances of the groups following it.
+func channelVersionHistory(ctx context.Context, service *update.Service, appId, channel string) ([]channelChange, error) {
+	groups, err := service.Group.List(appId).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	firstSeen := make(map[string]time.Time)
+	for _, group := range groups.Items {
+		if group.ChannelId != channel {
+			continue
+		}
+
+		call := service.Clientupdate.List()
+		call.AppId(appId)
+		call.GroupId(group.Id)
+		list, err := call.Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, cl := range list.Items {
+			seen, err := time.Parse(time.RFC3339, cl.LastSeen)
+			if err != nil {
+				continue
+			}
+			if t, ok := firstSeen[cl.Version]; !ok || seen.Before(t) {
+				firstSeen[cl.Version] = seen
+			}
+		}
+	}
+
+	versions := make([]string, 0, len(firstSeen))
+	for v := range firstSeen {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return firstSeen[versions[i]].Before(firstSeen[versions[j]])
+	})
+
+	// newest first
+	history := make([]channelChange, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		change := channelChange{
+			Time:       firstSeen[versions[i]].Format(time.RFC3339),
+			NewVersion: versions[i],
+		}
+		if i > 0 {
+			change.OldVersion = versions[i-1]
+		}
+		history = append(history, change)
+	}
+	return history, nil
+}
+
+func channelHistory(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if channelFlags.appId.Get() == nil || channelFlags.channel.Get() == nil {
+		return ERROR_USAGE
+	}
+
+	history, err := channelVersionHistory(ctx, service, channelFlags.appId.String(), channelFlags.channel.String())
+	if err != nil {
+		fatal(err)
+	}
+	if channelFlags.limit > 0 && len(history) > channelFlags.limit {
+		history = history[:channelFlags.limit]
+	}
+
+	if structuredOutput() {
+		return printStructured(out, history)
+	}
+
+	printHeader(out, "Time\tOld Version\tNew Version\n")
+	for _, change := range history {
+		fmt.Fprintf(out, "%s\t%s\t%s\n", change.Time, change.OldVersion, change.NewVersion)
+	}
+	out.Flush()
+	return OK
+}
+
+// findChannel returns the channel with the given label. If there is none it
+// reports so and returns the exit code to use.
+func findChannel(ctx context.Context, service *update.Service, appId, label string) (*update.AppChannel, int) {
+	channels, err := service.Channel.List(appId).Context(ctx).Do()
+	if err != nil {
+		fatal(err)
+	}
+	for _, c := range channels.Items {
+		if c.Label == label {
+			return c, OK
+		}
+	}
+	fmt.Fprintf(os.Stderr, "channel %q not found for application %s\n", label, appId)
+	return nil, ERROR_NOT_FOUND
+}
+
+// previousVersion returns the version that current replaced, given history
+// newest first, or "" if there is none. If no instance has reported current
+// yet it has no entry of its own, and the newest version seen is the one it
+// replaced.
+func previousVersion(history []channelChange, current string) string {
+	for i, change := range history {
+		if change.NewVersion == current {
+			if i+1 < len(history) {
+				return history[i+1].NewVersion
+			}
+			return ""
+		}
+	}
+	if len(history) > 0 {
+		return history[0].NewVersion
+	}
+	return ""
+}
+
+func channelRollback(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if channelFlags.appId.Get() == nil || channelFlags.channel.Get() == nil {
+		return ERROR_USAGE
+	}
+	appId, label := channelFlags.appId.String(), channelFlags.channel.String()
+
+	current, code := findChannel(ctx, service, appId, label)
+	if current == nil {
+		return code
+	}
+
+	history, err := channelVersionHistory(ctx, service, appId, label)
+	if err != nil {
+		fatal(err)
+	}
+
+	previous := previousVersion(history, current.Version)
+	if previous == "" {
+		fmt.Fprintf(os.Stderr, "no version before %s found in the history of channel %s\n", current.Version, label)
+		return ERROR_API
+	}
+
+	channelReq := &update.ChannelRequest{Version: previous, Publish: current.Publish, ForceSendFields: []string{"Publish"}}
+	if dryRun("PATCH", apiURL(service.BasePath, "apps", appId, "channels", label), channelReq) {
+		return OK
+	}
+	if !confirmDestructive(ctx, channelFlags.yes, fmt.Sprintf("Roll back channel %s from %s to %s?", label, current.Version, previous)) {
+		return ERROR_ABORTED
+	}
+
+	channel, err := service.Channel.Update(appId, label, channelReq).Context(ctx).Do()
+	if err != nil {
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, channel)
+	}
+
+	fmt.Fprintf(out, "rolled back channel %s from %s to %s\n", label, current.Version, channel.Version)
+	out.Flush()
+	return OK
+}
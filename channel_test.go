@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestPreviousVersion(t *testing.T) {
+	// newest first, as channelVersionHistory returns it
+	history := []channelChange{
+		{NewVersion: "1.2.0", OldVersion: "1.1.0"},
+		{NewVersion: "1.1.0", OldVersion: "1.0.0"},
+		{NewVersion: "1.0.0"},
+	}
+
+	tests := []struct {
+		name     string
+		history  []channelChange
+		current  string
+		previous string
+	}{
+		{"current is newest", history, "1.2.0", "1.1.0"},
+		{"current is in the middle", history, "1.1.0", "1.0.0"},
+		{"current is oldest", history, "1.0.0", ""},
+		{"current not reported yet", history, "1.3.0", "1.2.0"},
+		{"no history", nil, "1.0.0", ""},
+	}
+
+	for _, tt := range tests {
+		if got := previousVersion(tt.history, tt.current); got != tt.previous {
+			t.Errorf("%s: previousVersion(%q) = %q, want %q", tt.name, tt.current, got, tt.previous)
+		}
+	}
+}
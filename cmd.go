@@ -1,13 +1,27 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 	"text/tabwriter"
+	"time"
+
+	"github.com/pborman/uuid"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
 
 	"github.com/coreos/updateservicectl/auth"
 	"github.com/coreos/updateservicectl/client/update/v1"
@@ -20,6 +34,16 @@ const (
 	ERROR_API
 	ERROR_USAGE
 	ERROR_NO_COMMAND
+	ERROR_AUTH      // the server rejected the credentials (401 or 403)
+	ERROR_NOT_FOUND // the requested resource does not exist (404)
+	ERROR_NETWORK   // the server could not be reached
+	ERROR_INVALID   // the server rejected the request (any other 4xx)
+	ERROR_TIMEOUT   // --wait gave up before the rollout finished
+	ERROR_ABORTED   // a destructive command wasn't confirmed
+
+	// ERROR_INTERRUPTED is returned when a command is stopped by SIGINT or
+	// SIGTERM, following the shell convention of 128 + SIGINT.
+	ERROR_INTERRUPTED = 130
 
 	cliName        = "updateservicectl"
 	cliDescription = "updateservicectl is a command line driven interface to the roller."
@@ -27,7 +51,7 @@ const (
 
 type StringFlag struct {
 	value    *string
-	required bool
+	required bool // checked by missingFlags before the command runs
 }
 
 func (f *StringFlag) Set(value string) error {
@@ -46,6 +70,89 @@ func (f *StringFlag) String() string {
 	return ""
 }
 
+func (f *StringFlag) isRequired() bool {
+	return f.required
+}
+
+func (f *StringFlag) missing() bool {
+	return f.required && f.value == nil
+}
+
+// missingFlags returns the names of the required flags in fs that weren't
+// given, so they can all be reported at once.
+func missingFlags(fs *flag.FlagSet) []string {
+	var missing []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "app-id" && appLabelGiven(fs) {
+			return
+		}
+		if r, ok := f.Value.(interface{ missing() bool }); ok && r.missing() {
+			missing = append(missing, "--"+f.Name)
+		}
+	})
+	return missing
+}
+
+// BoolFlag is a bool flag that records whether it was given at all, for
+// updates that should leave a setting alone unless asked to change it. Like
+// a standard bool flag it can be given bare to set it to true.
+type BoolFlag struct {
+	value *bool
+}
+
+func (f *BoolFlag) Set(value string) error {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	f.value = &b
+	return nil
+}
+
+func (f *BoolFlag) Get() *bool {
+	return f.value
+}
+
+func (f *BoolFlag) String() string {
+	if f.value != nil {
+		return strconv.FormatBool(*f.value)
+	}
+	return ""
+}
+
+// IsBoolFlag lets the flag package accept the flag without a value.
+func (f *BoolFlag) IsBoolFlag() bool {
+	return true
+}
+
+// UUIDFlag is a StringFlag that only accepts UUIDs, so a mistyped ID is
+// rejected while parsing arguments instead of by the server.
+type UUIDFlag struct {
+	StringFlag
+}
+
+func (f *UUIDFlag) Set(value string) error {
+	if uuid.Parse(value) == nil {
+		return errors.New("not a valid UUID")
+	}
+	return f.StringFlag.Set(value)
+}
+
+// UUIDListFlag is a UUID flag that may be repeated to give several values.
+type UUIDListFlag []string
+
+func (f *UUIDListFlag) Set(value string) error {
+	if uuid.Parse(value) == nil {
+		return errors.New("not a valid UUID")
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+func (f *UUIDListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
 type Command struct {
 	Name        string       // Name of the Command and the string to use to invoke it
 	Summary     string       // One-sentence summary of what the Command does
@@ -54,51 +161,139 @@ type Command struct {
 	Flags       flag.FlagSet // Set of flags associated with this command
 	Run         handlerFunc  // Run a command with the given arguments
 	Subcommands []*Command   // Subcommands for this command.
+	Hidden      bool         // Leave the Command out of the global usage
+	NoAuth      bool         // Run can work without API credentials
+	AuthFor     func() bool  // with NoAuth, reports whether the flags given need credentials after all
 }
 
 var (
 	out           *tabwriter.Writer
+	stdout        io.Writer // destination that out writes through to
+	outFile       *os.File  // the --out-file stdout points to, if any
+	logFile       *os.File  // the --log-file API requests are logged to, if any
+	requestIDs    *requestIDRoundTripper
 	globalFlagSet *flag.FlagSet
 	commands      []*Command
 
+	// servers are the --server URLs, the first of which globalFlags.Server
+	// is set to. The others are fallbacks.
+	servers []string
+
+	// apiClient is the authenticated client built by handle(). Handlers
+	// making requests outside of the update.Service share it, and with it
+	// the connection pool.
+	apiClient *http.Client
+
+	// plainClient sends requests that aren't API calls and so aren't signed,
+	// such as Omaha update checks, through the same transport settings and
+	// logging as apiClient.
+	plainClient *http.Client
+
 	globalFlags struct {
-		Server        string
-		User          string
-		Key           string
-		Debug         bool
-		Version       bool
-		Help          bool
-		SkipSSLVerify bool
+		Server          string
+		APIPath         string
+		User            string
+		Key             string
+		Debug           bool
+		Version         bool
+		Help            bool
+		SkipSSLVerify   bool
+		Output          string
+		NoHeaders       bool
+		Quiet           bool
+		Timeout         time.Duration
+		AttemptTimeout  time.Duration
+		Retries         int
+		RetryWrites     bool
+		Concurrency     int
+		MaxIdleConns    int
+		CAFile          string
+		Proxy           string
+		Config          string
+		Profile         string
+		KeyFile         string
+		DryRun          bool
+		Anonymous       bool
+		HawkAlgorithm   string
+		HawkPayloadHash bool
+		CacheTTL        time.Duration
+		RefreshCache    bool
+		NoCache         bool
+		Headers         headerFlag
+		Color           string
+		Summary         bool
+		Trace           bool
+		ConfirmByName   bool
+		OutFile         string
+		LogFile         string
 	}
 )
 
 func init() {
+	stdout = os.Stdout
 	out = new(tabwriter.Writer)
-	out.Init(os.Stdout, 0, 8, 1, '\t', 0)
-
-	server := "http://localhost:8000" // default server
-	if serverEnv := os.Getenv("UPDATECTL_SERVER"); serverEnv != "" {
-		server = serverEnv
-	}
+	out.Init(stdout, 0, 8, 1, '\t', 0)
 
 	globalFlagSet = flag.NewFlagSet(cliName, flag.ExitOnError)
-	globalFlagSet.StringVar(&globalFlags.Server, "server", server, "Update server to connect to")
+	globalFlags.Server = "http://localhost:8000"
+	globalFlagSet.Var(&serverFlag{value: &globalFlags.Server}, "server", "Update server to connect to. Repeat it, or separate URLs with commas, to list fallbacks tried in order when a server can't be reached")
+	globalFlagSet.StringVar(&globalFlags.APIPath, "api-path", "/_ah/api/update/v1/", "Path the update API is mounted at on the server.")
 	globalFlagSet.BoolVar(&globalFlags.Debug, "debug", false, "Output debugging info to stderr")
+	globalFlagSet.StringVar(&globalFlags.LogFile, "log-file", "", "Append a JSON line for every API request, with its status and latency, to this file.")
 	globalFlagSet.BoolVar(&globalFlags.Version, "version", false, "Print version information and exit.")
 	globalFlagSet.BoolVar(&globalFlags.Help, "help", false, "Print usage information and exit.")
 	globalFlagSet.BoolVar(&globalFlags.SkipSSLVerify, "skip-ssl-verify", false, "Don't check SSL certificates.")
-	globalFlagSet.StringVar(&globalFlags.User, "user", os.Getenv("UPDATECTL_USER"), "API Username")
-	globalFlagSet.StringVar(&globalFlags.Key, "key", os.Getenv("UPDATECTL_KEY"), "API Key")
+	globalFlagSet.BoolVar(&globalFlags.SkipSSLVerify, "insecure-skip-verify", false, "Alias for --skip-ssl-verify.")
+	globalFlagSet.StringVar(&globalFlags.CAFile, "ca-file", "", "PEM bundle of CA certificates used to verify the server.")
+	globalFlagSet.StringVar(&globalFlags.Proxy, "proxy", "", "Proxy URL to send requests through. Overrides HTTP_PROXY, HTTPS_PROXY and NO_PROXY.")
+	globalFlagSet.StringVar(&globalFlags.User, "user", "", "API Username")
+	globalFlagSet.StringVar(&globalFlags.Key, "key", "", "API Key")
+	globalFlagSet.StringVar(&globalFlags.HawkAlgorithm, "hawk-algorithm", "sha256", "MAC algorithm requests are signed with: sha256 or sha1.")
+	globalFlagSet.BoolVar(&globalFlags.HawkPayloadHash, "hawk-payload-hash", false, "Include a hash of the request body in the signature of requests having one.")
+	globalFlagSet.Var(&globalFlags.Headers, "header", `Extra "Name: Value" header to send with every request. Can be repeated.`)
+	globalFlagSet.BoolVar(&globalFlags.Anonymous, "anonymous", false, "Send requests without credentials, for servers allowing unauthenticated access.")
+	globalFlagSet.StringVar(&globalFlags.KeyFile, "key-file", "", "File to read the API Key from. Takes precedence over --key.")
+	globalFlagSet.StringVar(&globalFlags.Config, "config", "", "Config file to read server and credentials from (default "+defaultConfigPath()+").")
+	globalFlagSet.StringVar(&globalFlags.Profile, "profile", "", "Config file profile, a [profile.<name>] table, whose server, user and key override the file's top level ones. Environment variables still take precedence.")
+	globalFlagSet.StringVar(&globalFlags.Output, "output", string(outputTable), "Output format: table, json, yaml, template=<go template>, env (shell variables, for commands showing one resource) or, for watch, prometheus.")
+	globalFlagSet.StringVar(&globalFlags.Output, "o", string(outputTable), "Shorthand for --output.")
+	globalFlagSet.StringVar(&globalFlags.OutFile, "out-file", "", "Write command output to this file, truncating it, instead of stdout.")
+	globalFlagSet.StringVar(&globalFlags.Color, "color", "auto", "Color the status and last-seen columns of table output: auto (only on a terminal), always or never. instance list-updates shows status with --format wide or --fields.")
+	globalFlagSet.BoolVar(&globalFlags.NoHeaders, "no-headers", false, "Don't print header rows in table output.")
+	globalFlagSet.BoolVar(&globalFlags.Quiet, "quiet", false, "Only print identifiers from list commands.")
+	globalFlagSet.BoolVar(&globalFlags.Quiet, "q", false, "Shorthand for --quiet.")
+	globalFlagSet.BoolVar(&globalFlags.DryRun, "dry-run", false, "Print the requests mutating commands would send instead of sending them.")
+	globalFlagSet.BoolVar(&globalFlags.Summary, "summary", false, "After the command, write a JSON line with its duration, HTTP call count and exit code to stderr.")
+	globalFlagSet.BoolVar(&globalFlags.ConfirmByName, "confirm-by-name", false, "Confirm deleting an app, package or user by typing its ID, version or name instead of answering yes. --yes still skips confirmation.")
+	globalFlagSet.BoolVar(&globalFlags.Trace, "trace", false, "Send OpenTelemetry spans of the command and its API requests to the OTLP/HTTP collector at $OTEL_EXPORTER_OTLP_ENDPOINT (default "+defaultOTLPEndpoint+"). A traceparent in $TRACEPARENT is used as the parent span.")
+	globalFlagSet.DurationVar(&globalFlags.Timeout, "timeout", 30*time.Second, "Timeout of each API call, retries and their delays included, 0 for none.")
+	globalFlagSet.DurationVar(&globalFlags.AttemptTimeout, "attempt-timeout", 0, "Timeout of each attempt at an API call, after which it is retried if --retries allows, 0 for none.")
+	globalFlagSet.IntVar(&globalFlags.Retries, "retries", 0, "Number of times to retry requests that fail with a connection error or 5xx status.")
+	globalFlagSet.BoolVar(&globalFlags.RetryWrites, "retry-writes", false, "Also retry non-idempotent (POST and PATCH) requests, and send them to fallback servers.")
+	globalFlagSet.IntVar(&globalFlags.MaxIdleConns, "max-idle-conns", 16, "Maximum number of idle connections to the server kept open for reuse.")
+	globalFlagSet.DurationVar(&globalFlags.CacheTTL, "cache-ttl", 0, "Cache the app list used to resolve --app-label for this long, 0 to not cache it.")
+	globalFlagSet.BoolVar(&globalFlags.RefreshCache, "refresh-cache", false, "Fetch the app list for --app-label even if the cached one hasn't expired.")
+	globalFlagSet.BoolVar(&globalFlags.NoCache, "no-cache", false, "Neither read nor update the app cache.")
+	globalFlagSet.IntVar(&globalFlags.Concurrency, "concurrency", 8, "Maximum number of requests commands spanning several applications make at once.")
 
 	commands = []*Command{
 		// admin.go
 		cmdAdminUser,
 		// app.go
 		cmdApp,
+		// apply.go
+		cmdApply,
+		cmdExport,
 		// channel.go
 		cmdChannel,
+		// completion.go
+		cmdCompletion,
+		// config.go
+		cmdConfig,
 		// database.go
 		cmdDatabase,
+		// docs.go
+		cmdGenDocs,
 		// group.go
 		cmdGroup,
 		// help.go
@@ -116,38 +311,230 @@ func init() {
 	}
 }
 
-type handlerFunc func([]string, *update.Service, *tabwriter.Writer) int
+type handlerFunc func(context.Context, []string, *update.Service, *tabwriter.Writer) int
 
-func getHawkClient(user string, key string) *http.Client {
-	return &http.Client{
-		Transport: &auth.HawkRoundTripper{
+func getHawkClient(user string, key string) (*http.Client, error) {
+	base, err := newBaseTransport()
+	if err != nil {
+		return nil, err
+	}
+	hasher, ok := auth.HawkAlgorithms[globalFlags.HawkAlgorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown --hawk-algorithm %q, expected sha256 or sha1", globalFlags.HawkAlgorithm)
+	}
+
+	var wire http.RoundTripper = base
+	if globalFlags.Debug {
+		// Log below the Hawk transport so the dump shows the request
+		// exactly as signed and sent, including retries.
+		wire = &debugRoundTripper{Transport: base, Out: os.Stderr}
+	}
+	requestIDs = &requestIDRoundTripper{Transport: wire}
+	wire = requestIDs
+	wire = &apiVersionRoundTripper{Transport: wire, Out: os.Stderr, Version: version.APIVersion}
+	if globalFlags.Summary {
+		wire = &countingRoundTripper{Transport: wire}
+	}
+	if globalFlags.LogFile != "" {
+		f, err := os.OpenFile(globalFlags.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		logFile = f
+		wire = &logRoundTripper{Transport: wire, Out: f}
+	}
+
+	plain := wire
+	if len(globalFlags.Headers) > 0 {
+		plain = &headerRoundTripper{Transport: wire, Header: globalFlags.Headers.Header()}
+	}
+	plainClient = &http.Client{Transport: plain, Timeout: globalFlags.Timeout}
+
+	transport := wire
+	if globalFlags.Anonymous {
+		transport = &readOnlyRoundTripper{Transport: wire}
+	} else {
+		transport = &auth.HawkRoundTripper{
 			User:          user,
 			Token:         key,
 			SkipSSLVerify: globalFlags.SkipSSLVerify,
-		},
+			Hash:          hasher,
+			PayloadHash:   globalFlags.HawkPayloadHash,
+			Transport:     wire,
+		}
+	}
+	if tracer != nil {
+		transport = &traceRoundTripper{Transport: transport, Tracer: tracer}
 	}
+	if len(servers) > 1 {
+		fallback := &fallbackRoundTripper{Transport: transport, Servers: servers, RetryWrites: globalFlags.RetryWrites}
+		if globalFlags.Debug {
+			fallback.Debug = os.Stderr
+		}
+		transport = fallback
+	}
+	if len(globalFlags.Headers) > 0 {
+		transport = &headerRoundTripper{Transport: transport, Header: globalFlags.Headers.Header()}
+	}
+	if globalFlags.Retries > 0 || globalFlags.AttemptTimeout > 0 {
+		transport = &retryRoundTripper{
+			Transport:      transport,
+			Retries:        globalFlags.Retries,
+			RetryWrites:    globalFlags.RetryWrites,
+			AttemptTimeout: globalFlags.AttemptTimeout,
+		}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   globalFlags.Timeout,
+	}, nil
+}
+
+// newService returns an update.Service sending requests through client to
+// the API at --server and --api-path. A single service is safe to use for
+// any number of calls, including concurrent ones.
+func newService(client *http.Client) (*update.Service, error) {
+	service, err := update.New(client)
+	if err != nil {
+		return nil, err
+	}
+	service.BasePath = globalFlags.Server + "/"
+	if p := strings.Trim(globalFlags.APIPath, "/"); p != "" {
+		service.BasePath += p + "/"
+	}
+	return service, nil
 }
 
-func handle(fn handlerFunc) func(f *flag.FlagSet) int {
+func handle(ctx context.Context, fn handlerFunc) func(f *flag.FlagSet) int {
 	return func(f *flag.FlagSet) (exit int) {
+		format, err := parseOutputFormat(globalFlags.Output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ERROR_USAGE
+		}
+		output = format
+
 		user := globalFlags.User
 		key := globalFlags.Key
-		client := getHawkClient(user, key)
-
-		service, err := update.New(client)
+		client, err := getHawkClient(user, key)
 		if err != nil {
-			log.Fatal(err)
+			fmt.Fprintln(os.Stderr, err)
+			return ERROR_USAGE
 		}
 
-		service.BasePath = globalFlags.Server + "/_ah/api/update/v1/"
-		exit = fn(f.Args(), service, out)
+		apiClient = client
+
+		service, err := newService(client)
+		if err != nil {
+			log.Print(err)
+			return ERROR_USAGE
+		}
+		if code := resolveAppLabel(ctx, service, f); code != OK {
+			return code
+		}
+		exit = fn(ctx, f.Args(), service, out)
 		return
 	}
 }
 
-func printVersion(out *tabwriter.Writer) {
+// fatal reports a failed API call and exits. Calls aborted by an interrupt
+// exit with ERROR_INTERRUPTED rather than logging the cancellation.
+func fatal(err error) {
+	clearProgress()
+	code := exitCode(err)
+	if code == ERROR_INTERRUPTED {
+		exitInterrupted()
+	}
+	out.Flush()
+	log.Print(redact(errorMessage(err)))
+	exitWith(code)
+}
+
+// errorMessage returns the text printed for err, followed by the request ID
+// the server gave the failed request, if it sent one.
+func errorMessage(err error) string {
+	var id string
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		id = apiErr.Header.Get(requestIDHeader)
+		if id == "" && requestIDs != nil {
+			id = requestIDs.LastID()
+		}
+	}
+	if id == "" {
+		return err.Error()
+	}
+	return fmt.Sprintf("%s (request ID: %s)", err, id)
+}
+
+// exitCode maps an error returned by the service, or by a plain HTTP request
+// to the server, to the exit code reporting its class of failure.
+func exitCode(err error) int {
+	var apiErr *googleapi.Error
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ERROR_INTERRUPTED
+	case errors.As(err, &apiErr):
+		return statusExitCode(apiErr.Code)
+	case errors.As(err, new(*readOnlyError)):
+		return ERROR_USAGE
+	case errors.As(err, &netErr):
+		return ERROR_NETWORK
+	}
+	return ERROR_API
+}
+
+func statusExitCode(status int) int {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ERROR_AUTH
+	case status == http.StatusNotFound:
+		return ERROR_NOT_FOUND
+	case status >= 400 && status < 500:
+		return ERROR_INVALID
+	}
+	return ERROR_API
+}
+
+// exitInterrupted flushes whatever a handler has written so far, since it may
+// have been stopped partway through a table, and exits.
+func exitInterrupted() {
+	clearProgress()
+	out.Flush()
+	fmt.Fprintln(os.Stderr, "interrupted")
+	exitWith(ERROR_INTERRUPTED)
+}
+
+// versionInfo is what --version prints with --output json or yaml.
+type versionInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"goVersion"`
+	GitCommit string `json:"gitCommit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+}
+
+func printVersion(out *tabwriter.Writer) int {
+	format, err := parseOutputFormat(globalFlags.Output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ERROR_USAGE
+	}
+	output = format
+
+	if structuredOutput() {
+		return printStructured(out, versionInfo{
+			Version:   version.Version,
+			GoVersion: runtime.Version(),
+			GitCommit: version.GitCommit,
+			BuildDate: version.BuildDate,
+		})
+	}
+
 	fmt.Fprintf(out, "%s version %s\n", cliName, version.Version)
 	out.Flush()
+	return OK
 }
 
 func getAllFlags() (flags []*flag.Flag) {
@@ -162,6 +549,20 @@ func getFlags(flagset *flag.FlagSet) (flags []*flag.Flag) {
 	return
 }
 
+// normalizeServer checks that server is an absolute http or https URL and
+// trims the right most slashes, because all other uses of globalFlags.Server
+// append the / already.
+func normalizeServer(server string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", fmt.Errorf("invalid --server %q: %v", server, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid --server %q: expected an http:// or https:// URL such as https://roller.example.com", server)
+	}
+	return strings.TrimRight(server, "/"), nil
+}
+
 // determine which Command should be run
 func findCommand(search string, args []string, commands []*Command) (cmd *Command, name string) {
 	if len(args) < 1 {
@@ -179,7 +580,11 @@ func findCommand(search string, args []string, commands []*Command) (cmd *Comman
 			// Clear the usage on flags as we will be printing our own
 			// usage after parsing arguments
 			c.Flags.Usage = func() {}
-			if errHelp := c.Flags.Parse(args[1:]); errHelp != nil {
+			if err := c.Flags.Parse(args[1:]); err == flag.ErrHelp {
+				printCommandUsage(cmd)
+				out.Flush()
+				os.Exit(OK)
+			} else if err != nil {
 				printCommandUsage(cmd)
 				os.Exit(ERROR_USAGE)
 			}
@@ -201,9 +606,23 @@ func main() {
 	globalFlagSet.Parse(os.Args[1:])
 	var args = globalFlagSet.Args()
 
+	if err := loadGlobalEnv(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ERROR_USAGE)
+	}
+
+	if globalFlags.OutFile != "" {
+		f, err := os.Create(globalFlags.OutFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(ERROR_USAGE)
+		}
+		outFile, stdout = f, f
+		out.Init(stdout, 0, 8, 1, '\t', 0)
+	}
+
 	if globalFlags.Version {
-		printVersion(out)
-		os.Exit(OK)
+		exitWith(printVersion(out))
 	}
 
 	if globalFlags.Help {
@@ -211,26 +630,93 @@ func main() {
 		os.Exit(OK)
 	}
 
-	// trim the right most slash because all other uses of globalFlags.Server
-	// append the / already
-	globalFlags.Server = strings.TrimRight(globalFlags.Server, "/")
+	if err := loadGlobalConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ERROR_USAGE)
+	}
+
+	if globalFlags.KeyFile != "" {
+		key, err := ioutil.ReadFile(globalFlags.KeyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(ERROR_USAGE)
+		}
+		globalFlags.Key = strings.TrimRight(string(key), "\r\n")
+		setSource("key", "file ("+globalFlags.KeyFile+")")
+	}
+
+	for _, s := range strings.Split(globalFlags.Server, ",") {
+		server, err := normalizeServer(strings.TrimSpace(s))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(ERROR_USAGE)
+		}
+		servers = append(servers, server)
+	}
+	globalFlags.Server = servers[0]
+
+	var err error
+	if colorOutput, err = parseColorMode(globalFlags.Color); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ERROR_USAGE)
+	}
+
+	if globalFlags.Concurrency < 1 {
+		fmt.Fprintln(os.Stderr, "--concurrency must be at least 1")
+		os.Exit(ERROR_USAGE)
+	}
 
 	cmd, name := findCommand("", args, commands)
 
 	if cmd == nil {
-		fmt.Printf("%v: unknown subcommand: %q\n", cliName, name)
-		fmt.Printf("Run '%v --help' for usage.\n", cliName)
-		os.Exit(ERROR_NO_COMMAND)
+		fmt.Fprintf(out, "%v: unknown subcommand: %q\n", cliName, name)
+		fmt.Fprintf(out, "Run '%v --help' for usage.\n", cliName)
+		out.Flush()
+		exitWith(ERROR_NO_COMMAND)
 	}
 
 	if cmd.Run == nil {
 		printCommandUsage(cmd)
 		os.Exit(ERROR_USAGE)
 	} else {
-		exit := handle(cmd.Run)(&cmd.Flags)
+		if missing := missingFlags(&cmd.Flags); len(missing) > 0 {
+			fmt.Fprintf(os.Stderr, "missing required flags: %s\n", strings.Join(missing, ", "))
+			printCommandUsage(cmd)
+			os.Exit(ERROR_USAGE)
+		}
+
+		// Prompt for a missing key rather than letting the API reject the
+		// request, but only when someone is there to answer.
+		needsAuth := !cmd.NoAuth || (cmd.AuthFor != nil && cmd.AuthFor())
+		if needsAuth && !globalFlags.Anonymous && globalFlags.User != "" && globalFlags.Key == "" && isTerminal(os.Stdin) {
+			if key, err := readPassword("API key: "); err == nil {
+				globalFlags.Key = key
+			}
+		}
+		if needsAuth && !globalFlags.Anonymous && (globalFlags.User == "" || globalFlags.Key == "") {
+			fmt.Fprintln(os.Stderr, "no credentials: set --user/--key or UPDATECTL_USER/UPDATECTL_KEY, or pass --anonymous")
+			os.Exit(ERROR_USAGE)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigc
+			cancel()
+		}()
+
+		summaryCommand, summaryStart = cmd.Name, time.Now()
+		if globalFlags.Trace {
+			startTrace(cmd.Name)
+		}
+		exit := handle(ctx, cmd.Run)(&cmd.Flags)
+		if ctx.Err() != nil {
+			exitInterrupted()
+		}
 		if exit == ERROR_USAGE {
 			printCommandUsage(cmd)
 		}
-		os.Exit(exit)
+		exitWith(exit)
 	}
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+)
+
+func TestBoolFlag(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string // String() of the flag, empty when it wasn't given
+	}{
+		{args: []string{"--enabled"}, want: "true"},
+		{args: []string{"--enabled=true"}, want: "true"},
+		{args: []string{"--enabled=false"}, want: "false"},
+		{args: []string{}, want: ""},
+	}
+
+	for _, tt := range tests {
+		var enabled BoolFlag
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		fs.Var(&enabled, "enabled", "")
+
+		if err := fs.Parse(tt.args); err != nil {
+			t.Errorf("%v: unexpected error: %v", tt.args, err)
+			continue
+		}
+		if got := enabled.String(); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.args, got, tt.want)
+		}
+		if (enabled.Get() == nil) != (tt.want == "") {
+			t.Errorf("%v: Get() = %v, want it set only when the flag is given", tt.args, enabled.Get())
+		}
+	}
+}
+
+func TestBoolFlagInvalid(t *testing.T) {
+	var enabled BoolFlag
+	if err := enabled.Set("maybe"); err == nil {
+		t.Error("expected an error for an invalid value")
+	}
+	if enabled.Get() != nil {
+		t.Errorf("invalid value was stored: %q", enabled.String())
+	}
+}
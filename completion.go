@@ -0,0 +1,228 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/net/context"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+var (
+	cmdCompletion = &Command{
+		Name:    "completion",
+		Summary: "Generate shell completion scripts.",
+		Hidden:  true,
+		Subcommands: []*Command{
+			cmdCompletionBash,
+			cmdCompletionZsh,
+		},
+	}
+	cmdCompletionBash = &Command{
+		Name:    "completion bash",
+		Usage:   "",
+		Summary: "Generate a bash completion script.",
+		Description: `Print a bash completion script for command and flag names. Load it with:
+
+	source <(updateservicectl completion bash)`,
+		Run:    completionBash,
+		NoAuth: true,
+	}
+	cmdCompletionZsh = &Command{
+		Name:    "completion zsh",
+		Usage:   "",
+		Summary: "Generate a zsh completion function.",
+		Description: `Print a zsh completion function for command and flag names. Load it with:
+
+	source <(updateservicectl completion zsh)
+
+or save it as _updateservicectl in a directory on $fpath.`,
+		Run:    completionZsh,
+		NoAuth: true,
+	}
+)
+
+// walkCommands calls fn for each command in cmds and, depth first, for each
+// of their subcommands.
+func walkCommands(cmds []*Command, fn func(cmd *Command)) {
+	for _, cmd := range cmds {
+		fn(cmd)
+		walkCommands(cmd.Subcommands, fn)
+	}
+}
+
+// commandWord returns the word that invokes cmd below its parent, the last
+// word of its Name.
+func commandWord(cmd *Command) string {
+	fields := strings.Fields(cmd.Name)
+	return fields[len(fields)-1]
+}
+
+// flagNames returns the names of the flags in fs as typed on the command
+// line, "-o" or "--output".
+func flagNames(fs *flag.FlagSet) []string {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		prefix := "--"
+		if len(f.Name) == 1 {
+			prefix = "-"
+		}
+		names = append(names, prefix+f.Name)
+	})
+	return names
+}
+
+func commandWords(cmds []*Command) []string {
+	words := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		words = append(words, commandWord(cmd))
+	}
+	return words
+}
+
+// commandPattern returns a shell case pattern matching the full name of any
+// command, such as "app create".
+func commandPattern() string {
+	var names []string
+	walkCommands(commands, func(cmd *Command) {
+		names = append(names, fmt.Sprintf("%q", cmd.Name))
+	})
+	return strings.Join(names, "|")
+}
+
+func completionBash(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	writeBashCompletion(stdout)
+	return OK
+}
+
+// writeBashCompletion writes a completion function that works out which
+// command is being typed from the words so far, then offers its subcommands
+// or, after a dash, its flags. Global flags are offered before the command,
+// which is the only place they are accepted.
+func writeBashCompletion(w io.Writer) {
+	fn := "_" + cliName
+
+	fmt.Fprintf(w, "# bash completion for %s\n\n", cliName)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprint(w, `	local cur="${COMP_WORDS[COMP_CWORD]}"
+	local cmd="" candidate i
+
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		case "${COMP_WORDS[i]}" in
+		-*) continue ;;
+		esac
+		candidate="${cmd:+$cmd }${COMP_WORDS[i]}"
+		case "$candidate" in
+`)
+	fmt.Fprintf(w, "\t\t%s)\n\t\t\tcmd=\"$candidate\" ;;\n", commandPattern())
+	fmt.Fprint(w, `		esac
+	done
+
+	local subs="" flags=""
+	case "$cmd" in
+`)
+	fmt.Fprintf(w, "\t\"\")\n\t\tsubs=%q\n\t\tflags=%q\n\t\t;;\n",
+		strings.Join(commandWords(commands), " "), strings.Join(flagNames(globalFlagSet), " "))
+	walkCommands(commands, func(cmd *Command) {
+		fmt.Fprintf(w, "\t%q)\n\t\tsubs=%q\n\t\tflags=%q\n\t\t;;\n",
+			cmd.Name, strings.Join(commandWords(cmd.Subcommands), " "), strings.Join(flagNames(&cmd.Flags), " "))
+	})
+	fmt.Fprint(w, `	esac
+
+	case "$cur" in
+	-*) COMPREPLY=($(compgen -W "$flags" -- "$cur")) ;;
+	*) COMPREPLY=($(compgen -W "$subs" -- "$cur")) ;;
+	esac
+}
+
+`)
+	fmt.Fprintf(w, "complete -F %s %s\n", fn, cliName)
+}
+
+func completionZsh(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	writeZshCompletion(stdout)
+	return OK
+}
+
+// zshQuote single quotes s for zsh.
+func zshQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// zshItem formats a name and its description as a _describe array element.
+func zshItem(name, desc string) string {
+	desc = strings.SplitN(strings.TrimSpace(desc), "\n", 2)[0]
+	return zshQuote(strings.Replace(name, ":", `\:`, -1) + ":" + desc)
+}
+
+func zshCommandItems(cmds []*Command) string {
+	items := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		desc := cmd.Summary
+		if desc == "" {
+			desc = cmd.Description
+		}
+		items = append(items, zshItem(commandWord(cmd), desc))
+	}
+	return strings.Join(items, " ")
+}
+
+func zshFlagItems(fs *flag.FlagSet) string {
+	var items []string
+	names := flagNames(fs)
+	i := 0
+	fs.VisitAll(func(f *flag.Flag) {
+		items = append(items, zshItem(names[i], f.Usage))
+		i++
+	})
+	return strings.Join(items, " ")
+}
+
+// writeZshCompletion writes the zsh counterpart of writeBashCompletion,
+// describing each subcommand with its Summary and each flag with its usage.
+func writeZshCompletion(w io.Writer) {
+	fn := "_" + cliName
+
+	fmt.Fprintf(w, "#compdef %s\n\n", cliName)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprint(w, `	local cmd="" candidate i
+	local -a subs flags
+
+	for ((i = 2; i < CURRENT; i++)); do
+		[[ ${words[i]} == -* ]] && continue
+		candidate="${cmd:+$cmd }${words[i]}"
+		case "$candidate" in
+`)
+	fmt.Fprintf(w, "\t\t(%s)\n\t\t\tcmd=\"$candidate\" ;;\n", commandPattern())
+	fmt.Fprint(w, `		esac
+	done
+
+	case "$cmd" in
+`)
+	fmt.Fprintf(w, "\t(\"\")\n\t\tsubs=(%s)\n\t\tflags=(%s)\n\t\t;;\n",
+		zshCommandItems(commands), zshFlagItems(globalFlagSet))
+	walkCommands(commands, func(cmd *Command) {
+		fmt.Fprintf(w, "\t(%q)\n\t\tsubs=(%s)\n\t\tflags=(%s)\n\t\t;;\n",
+			cmd.Name, zshCommandItems(cmd.Subcommands), zshFlagItems(&cmd.Flags))
+	})
+	fmt.Fprint(w, `	esac
+
+	if [[ ${words[CURRENT]} == -* ]]; then
+		_describe -t flags 'flag' flags
+	else
+		_describe -t commands 'command' subs
+	fi
+}
+
+`)
+	fmt.Fprintf(w, `if [[ "${funcstack[1]}" == %[1]s ]]; then
+	%[1]s "$@"
+else
+	compdef %[1]s %[2]s
+fi
+`, fn, cliName)
+}
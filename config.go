@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/net/context"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+// The config file is a small subset of TOML: top level keys hold the default
+// settings and [profile.<name>] tables hold named overrides selected with
+// --profile. For example:
+//
+//	server = "https://roller.example.com"
+//	user = "admin"
+//	key = "..."
+//
+//	[profile.staging]
+//	server = "https://roller.staging.example.com"
+//
+// A [columns] table renames the headers of table columns, keyed by the names
+// --fields takes:
+//
+//	[columns]
+//	last-seen = "Heartbeat"
+
+const (
+	configProfilePrefix = "profile."
+	configColumnsTable  = "columns"
+)
+
+// configKeys maps the settings read from the config file to the global flag
+// and environment variable they stand in for.
+var configKeys = []struct {
+	name string
+	env  string
+}{
+	{"server", "UPDATECTL_SERVER"},
+	{"user", "UPDATECTL_USER"},
+	{"key", "UPDATECTL_KEY"},
+	{"cache-ttl", "UPDATECTL_CACHE_TTL"},
+	{"confirm-by-name", "UPDATECTL_CONFIRM_BY_NAME"},
+}
+
+type config struct {
+	// tables maps a table name to its keys. Top level keys are stored under
+	// the empty name.
+	tables map[string]map[string]string
+}
+
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, "updatectl", "config.toml")
+}
+
+func parseConfig(path string) (*config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &config{tables: map[string]map[string]string{"": {}}}
+	table := ""
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if strings.HasPrefix(table, configProfilePrefix) {
+				name, err := parseConfigValue(strings.TrimPrefix(table, configProfilePrefix))
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: %v", path, lineno, err)
+				}
+				table = configProfilePrefix + name
+			}
+			if c.tables[table] == nil {
+				c.tables[table] = map[string]string{}
+			}
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, lineno)
+		}
+		key := strings.TrimSpace(parts[0])
+		value, err := parseConfigValue(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineno, err)
+		}
+		c.tables[table][key] = value
+	}
+	return c, scanner.Err()
+}
+
+// parseConfigValue unquotes a basic ("...") or literal ('...') string,
+// discarding any trailing comment. Other values are returned as is.
+func parseConfigValue(value string) (string, error) {
+	end := strings.Index(value, "#")
+	if end < 0 {
+		end = len(value)
+	}
+
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end = 1
+		for end < len(value) && value[end] != '"' {
+			if value[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(value) {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		end++
+	case strings.HasPrefix(value, "'"):
+		end = strings.Index(value[1:], "'") + 2
+		if end < 2 {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+	}
+
+	if rest := strings.TrimSpace(value[end:]); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected %q after value", rest)
+	}
+
+	value = strings.TrimSpace(value[:end])
+	if strings.HasPrefix(value, `"`) {
+		return strconv.Unquote(value)
+	}
+	return strings.Trim(value, "'"), nil
+}
+
+// profiles returns the names of the profiles in c, sorted.
+func (c *config) profiles() []string {
+	var names []string
+	for table := range c.tables {
+		if strings.HasPrefix(table, configProfilePrefix) {
+			names = append(names, strings.TrimPrefix(table, configProfilePrefix))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// settings returns the top level settings overlaid with those of the named
+// profile, if any.
+func (c *config) settings(profile string) (map[string]string, error) {
+	settings := make(map[string]string)
+	for k, v := range c.tables[""] {
+		settings[k] = v
+	}
+	if profile == "" {
+		return settings, nil
+	}
+
+	table, ok := c.tables[configProfilePrefix+profile]
+	if !ok {
+		profiles := c.profiles()
+		if len(profiles) == 0 {
+			return nil, fmt.Errorf("profile %q not found in config file, which has no profiles", profile)
+		}
+		return nil, fmt.Errorf("profile %q not found in config file, available profiles: %s", profile, strings.Join(profiles, ", "))
+	}
+	for k, v := range table {
+		settings[k] = v
+	}
+	return settings, nil
+}
+
+// envPrefix is prepended to the upper cased name of a global flag, with
+// dashes turned into underscores, to get the environment variable setting it.
+const envPrefix = "UPDATECTL_"
+
+func flagEnvName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// envSettings are the global flags that can be set from the environment.
+// Flags that only make sense for one invocation, such as --help, --version,
+// --config and --out-file, and the shorthands and aliases of listed ones are
+// left out.
+var envSettings = []string{
+	"server", "api-path", "user", "key", "key-file", "anonymous", "profile",
+	"hawk-algorithm", "hawk-payload-hash", "header",
+	"skip-ssl-verify", "ca-file", "proxy",
+	"timeout", "attempt-timeout", "retries", "retry-writes", "max-idle-conns", "concurrency",
+	"output", "color", "no-headers", "quiet", "confirm-by-name",
+	"debug", "log-file", "summary", "trace", "dry-run",
+	"cache-ttl", "refresh-cache", "no-cache",
+}
+
+// loadGlobalEnv sets each of envSettings not given on the command line from
+// its environment variable, if that is set. Aliases share the variable they
+// set, so giving either one on the command line overrides the environment
+// for both.
+func loadGlobalEnv() error {
+	set := make(map[uintptr]bool)
+	globalFlagSet.Visit(func(f *flag.Flag) {
+		set[reflect.ValueOf(f.Value).Pointer()] = true
+		setSource(f.Name, sourceFlag)
+	})
+
+	for _, name := range envSettings {
+		f := globalFlagSet.Lookup(name)
+		env := flagEnvName(name)
+		value, ok := os.LookupEnv(env)
+		if !ok || set[reflect.ValueOf(f.Value).Pointer()] {
+			continue
+		}
+		if err := globalFlagSet.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %v", value, env, err)
+		}
+		setSource(name, "env ("+env+")")
+	}
+	return nil
+}
+
+// loadGlobalConfig fills in global flags from the config file. Values given
+// on the command line or in the environment take precedence over the file.
+func loadGlobalConfig() error {
+	path := globalFlags.Config
+	if path == "" {
+		path = defaultConfigPath()
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if globalFlags.Profile != "" {
+				return fmt.Errorf("profile %q requested but %s does not exist", globalFlags.Profile, path)
+			}
+			return nil
+		}
+	}
+
+	c, err := parseConfig(path)
+	if err != nil {
+		return err
+	}
+	settings, err := c.settings(globalFlags.Profile)
+	if err != nil {
+		return err
+	}
+	columnAliases = c.tables[configColumnsTable]
+
+	set := make(map[string]bool)
+	globalFlagSet.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for _, k := range configKeys {
+		value, ok := settings[k.name]
+		if !ok || set[k.name] || os.Getenv(k.env) != "" {
+			continue
+		}
+		if err := globalFlagSet.Set(k.name, value); err != nil {
+			return err
+		}
+		source := path
+		if _, ok := c.tables[configProfilePrefix+globalFlags.Profile][k.name]; ok {
+			source += " [" + configProfilePrefix + globalFlags.Profile + "]"
+		}
+		setSource(k.name, "file ("+source+")")
+	}
+	return nil
+}
+
+// Sources of global settings not read from an environment variable or file.
+const (
+	sourceFlag    = "flag"
+	sourceDefault = "default"
+)
+
+// settingSources records where each global setting came from, keyed by the
+// variable the flag sets so aliases share it.
+var settingSources = make(map[uintptr]string)
+
+func setSource(name, source string) {
+	settingSources[reflect.ValueOf(globalFlagSet.Lookup(name).Value).Pointer()] = source
+}
+
+func settingSource(f *flag.Flag) string {
+	if s, ok := settingSources[reflect.ValueOf(f.Value).Pointer()]; ok {
+		return s
+	}
+	return sourceDefault
+}
+
+var (
+	cmdConfig = &Command{
+		Name:    "config",
+		Summary: "Operations on the configuration.",
+		Subcommands: []*Command{
+			cmdConfigShow,
+		},
+	}
+
+	cmdConfigShow = &Command{
+		Name:    "config show",
+		Usage:   "[OPTION]...",
+		Summary: "Show the value of every global option and where it came from.",
+		Description: `Prints each global option after merging the command line, UPDATECTL_*
+environment variables and the config file, with the source of its value: flag,
+env (naming the variable), file (naming the file), or default. The API key,
+Authorization and Proxy-Authorization headers and passwords in the server and
+proxy URLs are redacted.`,
+		Run:    configShow,
+		NoAuth: true,
+	}
+)
+
+// configSetting is a line of config show.
+type configSetting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+func configShow(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	var settings []configSetting
+	globalFlagSet.VisitAll(func(f *flag.Flag) {
+		if len(f.Name) == 1 || f.Name == "help" || f.Name == "version" {
+			return
+		}
+		value := f.Value.String()
+		switch f.Name {
+		case "server":
+			redactedServers := make([]string, len(servers))
+			for i, s := range servers {
+				redactedServers[i] = redactURL(s)
+			}
+			value = strings.Join(redactedServers, ",")
+		case "proxy":
+			value = redactURL(value)
+		case "header":
+			value = globalFlags.Headers.redacted()
+		case "key":
+			if value != "" {
+				value = redacted
+			}
+		}
+		settings = append(settings, configSetting{Name: f.Name, Value: value, Source: settingSource(f)})
+	})
+
+	if structuredOutput() {
+		return printStructured(out, settings)
+	}
+
+	printHeader(out, "Setting\tValue\tSource\n")
+	for _, s := range settings {
+		fmt.Fprintf(out, "%s\t%s\t%s\n", s.Name, s.Value, s.Source)
+	}
+	out.Flush()
+	return OK
+}
@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestEnvSettings(t *testing.T) {
+	for _, name := range envSettings {
+		if globalFlagSet.Lookup(name) == nil {
+			t.Errorf("envSettings names %q, which isn't a global flag", name)
+		}
+	}
+	for _, name := range []string{"help", "version", "config", "out-file"} {
+		for _, s := range envSettings {
+			if s == name {
+				t.Errorf("--%s can be set from the environment", name)
+			}
+		}
+	}
+}
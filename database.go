@@ -1,18 +1,28 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"text/tabwriter"
 
+	"golang.org/x/net/context"
+
 	"github.com/coreos/updateservicectl/client/update/v1"
 )
 
 var (
+	databaseFlags struct {
+		out string
+		in  string
+	}
+
 	cmdDatabase = &Command{
 		Name:    "database",
 		Usage:   "",
@@ -20,13 +30,16 @@ var (
 		Subcommands: []*Command{
 			cmdDatabaseInit,
 			cmdDatabaseBackup,
+			cmdDatabaseDump,
+			cmdDatabaseRestore,
 		},
 	}
 	cmdDatabaseInit = &Command{
 		Name:        "database init",
 		Usage:       "",
-		Description: "Initialize the database.",
+		Description: "Initialize the database. Running it against an initialized database does nothing.",
 		Run:         databaseInit,
+		NoAuth:      true,
 	}
 	cmdDatabaseBackup = &Command{
 		Name:        "database backup",
@@ -34,50 +47,280 @@ var (
 		Description: "Grab a backup of the database.",
 		Run:         databaseBackup,
 	}
+	cmdDatabaseDump = &Command{
+		Name:  "database dump",
+		Usage: "[OPTION]...",
+		Description: `Export apps and their packages, channels and groups to a JSON file (--out)
+that database restore can read back.`,
+		Run: databaseDump,
+	}
+	cmdDatabaseRestore = &Command{
+		Name:        "database restore",
+		Usage:       "[OPTION]...",
+		Description: `Create the apps, packages, channels and groups exported by database dump (--in).`,
+		Run:         databaseRestore,
+	}
 )
 
-func databaseInit(args []string, service *update.Service, out *tabwriter.Writer) int {
+func init() {
+	cmdDatabaseDump.Flags.StringVar(&databaseFlags.out, "out", "", "File to write the export to.")
+	cmdDatabaseRestore.Flags.StringVar(&databaseFlags.in, "in", "", "File to read the export from.")
+}
+
+func databaseInit(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	adminUrl := globalFlags.Server + "/admin/v1/init"
-	client := &http.Client{}
-	resp, err := client.Get(adminUrl)
+	if dryRun("GET", adminUrl, nil) {
+		return OK
+	}
+	req, err := http.NewRequest("GET", adminUrl, nil)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "initializing database at %s...\n", globalFlags.Server)
+	resp, err := plainClient.Do(req.WithContext(ctx))
+	if err != nil {
+		fatal(err)
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
-	fmt.Println(string(body))
-	if string(body) != "ok" {
-		return ERROR_API
+
+	switch {
+	case resp.StatusCode == http.StatusConflict:
+		// the init endpoint answers 409 for an initialized database
+		fmt.Fprintln(out, "database already initialized, nothing to do")
+		out.Flush()
+		return OK
+	case resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != "ok":
+		fmt.Fprintf(os.Stderr, "database init failed: %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+		return statusExitCode(resp.StatusCode)
 	}
+	fmt.Fprintln(out, "database ready")
+	out.Flush()
 	return OK
 }
 
-func databaseBackup(args []string, service *update.Service, out *tabwriter.Writer) int {
+func databaseBackup(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if len(args) != 1 {
 		return ERROR_USAGE
 	}
 	backupUrl := globalFlags.Server + "/db/backup"
-	client := getHawkClient(globalFlags.User, globalFlags.Key)
-	resp, err := client.Get(backupUrl)
+	req, err := http.NewRequest("GET", backupUrl, nil)
+	if err != nil {
+		fatal(err)
+	}
+	resp, err := apiClient.Do(req.WithContext(ctx))
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 	if resp.StatusCode != http.StatusOK {
 		body, _ := ioutil.ReadAll(resp.Body)
-		log.Fatal(string(body))
+		log.Print(string(body))
+		exitWith(statusExitCode(resp.StatusCode))
 	}
 	defer resp.Body.Close()
-	outFile, err := os.Create(args[0])
+	f, err := os.Create(args[0])
+	if err != nil {
+		log.Print(err)
+		return ERROR_USAGE
+	}
+	defer f.Close()
+	_, err = io.Copy(f, resp.Body)
+	if err != nil {
+		fatal(err)
+	}
+	return OK
+}
+
+// dumpPageSize is the number of packages fetched per request while dumping.
+const dumpPageSize = 100
+
+// dumpRecord is one element of the JSON array written by database dump.
+// Exactly one of the resource fields is set, as named by Kind. Records are
+// written in an order that restore can replay: each app, then its packages,
+// then the channels pointing at them, then the groups following those.
+type dumpRecord struct {
+	Kind    string             `json:"kind"`
+	App     *update.App        `json:"app,omitempty"`
+	Package *update.Package    `json:"package,omitempty"`
+	Channel *update.AppChannel `json:"channel,omitempty"`
+	Group   *update.Group      `json:"group,omitempty"`
+}
+
+var dumpKinds = []string{"app", "package", "channel", "group"}
+
+func printDumpCounts(out *tabwriter.Writer, counts map[string]int) {
+	printHeader(out, "Resource\tCount\n")
+	for _, kind := range dumpKinds {
+		fmt.Fprintf(out, "%s\t%d\n", kind, counts[kind])
+	}
+	out.Flush()
+}
+
+func databaseDump(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if databaseFlags.out == "" {
+		return ERROR_USAGE
+	}
+
+	f, err := os.Create(databaseFlags.out)
 	if err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		return ERROR_USAGE
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	// Records are written as they are fetched so the export is never held
+	// in memory as a whole.
+	counts := make(map[string]int)
+	n := 0
+	write := func(r dumpRecord) {
+		b, err := json.Marshal(r)
+		if err != nil {
+			fatal(err)
+		}
+		if n > 0 {
+			w.WriteString(",\n")
+		}
+		w.Write(b)
+		n++
+		counts[r.Kind]++
+		showProgress("exported %d records...", n)
 	}
-	defer outFile.Close()
-	_, err = io.Copy(outFile, resp.Body)
+
+	apps, err := service.App.List().Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	w.WriteString("[\n")
+	for _, app := range apps.Items {
+		write(dumpRecord{Kind: "app", App: app})
+
+		for skip := int64(0); ; skip += dumpPageSize {
+			call := service.App.Package.List(app.Id)
+			call.Limit(dumpPageSize)
+			call.Skip(skip)
+			pkgs, err := call.Context(ctx).Do()
+			if err != nil {
+				fatal(err)
+			}
+			for _, pkg := range pkgs.Items {
+				write(dumpRecord{Kind: "package", Package: pkg})
+			}
+			if len(pkgs.Items) < dumpPageSize {
+				break
+			}
+		}
+
+		channels, err := service.Channel.List(app.Id).Context(ctx).Do()
+		if err != nil {
+			fatal(err)
+		}
+		for _, channel := range channels.Items {
+			write(dumpRecord{Kind: "channel", Channel: channel})
+		}
+
+		groups, err := service.Group.List(app.Id).Context(ctx).Do()
+		if err != nil {
+			fatal(err)
+		}
+		for _, group := range groups.Items {
+			write(dumpRecord{Kind: "group", Group: group})
+		}
+	}
+	w.WriteString("\n]\n")
+
+	clearProgress()
+	if err := w.Flush(); err != nil {
+		log.Print(err)
+		return ERROR_USAGE
 	}
+
+	printDumpCounts(out, counts)
 	return OK
 }
+
+func databaseRestore(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if databaseFlags.in == "" {
+		return ERROR_USAGE
+	}
+
+	f, err := os.Open(databaseFlags.in)
+	if err != nil {
+		log.Print(err)
+		return ERROR_USAGE
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		fmt.Fprintf(os.Stderr, "%s is not a database dump\n", databaseFlags.in)
+		return ERROR_USAGE
+	}
+
+	counts := make(map[string]int)
+	for dec.More() {
+		var r dumpRecord
+		if err := dec.Decode(&r); err != nil {
+			fmt.Fprintf(os.Stderr, "reading %s failed: %v\n", databaseFlags.in, err)
+			return ERROR_USAGE
+		}
+		if err := restoreRecord(ctx, service, r); err != nil {
+			if ctx.Err() != nil {
+				fatal(err)
+			}
+			fmt.Fprintf(os.Stderr, "restoring %s failed: %s\n", r.Kind, redact(errorMessage(err)))
+			printDumpCounts(out, counts)
+			return exitCode(err)
+		}
+		counts[r.Kind]++
+	}
+
+	printDumpCounts(out, counts)
+	return OK
+}
+
+func restoreRecord(ctx context.Context, service *update.Service, r dumpRecord) error {
+	var err error
+	switch {
+	case r.Kind == "app" && r.App != nil:
+		req := &update.AppInsertReq{
+			Id:          r.App.Id,
+			Label:       r.App.Label,
+			Description: r.App.Description,
+		}
+		if dryRun("POST", apiURL(service.BasePath, "apps"), req) {
+			return nil
+		}
+		_, err = service.App.Insert(req).Context(ctx).Do()
+	case r.Kind == "package" && r.Package != nil:
+		pkg := r.Package
+		if dryRun("POST", apiURL(service.BasePath, "apps", pkg.AppId, "packages", pkg.Version), pkg) {
+			return nil
+		}
+		_, err = service.App.Package.Insert(pkg.AppId, pkg.Version, pkg).Context(ctx).Do()
+	case r.Kind == "channel" && r.Channel != nil:
+		req := &update.ChannelRequest{
+			AppId:   r.Channel.AppId,
+			Label:   r.Channel.Label,
+			Publish: r.Channel.Publish,
+			Version: r.Channel.Version,
+		}
+		if dryRun("POST", apiURL(service.BasePath, "apps", req.AppId, "channels"), req) {
+			return nil
+		}
+		_, err = service.Channel.Insert(req.AppId, req).Context(ctx).Do()
+	case r.Kind == "group" && r.Group != nil:
+		group := r.Group
+		if dryRun("POST", apiURL(service.BasePath, "apps", group.AppId, "groups"), group) {
+			return nil
+		}
+		_, err = service.Group.Insert(group.AppId, group).Context(ctx).Do()
+	default:
+		err = fmt.Errorf("unknown record kind %q", r.Kind)
+	}
+	return err
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/net/context"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/version"
+)
+
+var (
+	genDocsFlags struct {
+		dir string
+	}
+
+	cmdGenDocs = &Command{
+		Name:        "gendocs",
+		Usage:       "[OPTION]...",
+		Summary:     "Generate man pages.",
+		Description: `Write a section 1 man page for updateservicectl and for each of its commands to --dir.`,
+		Hidden:      true,
+		NoAuth:      true,
+		Run:         genDocs,
+	}
+)
+
+func init() {
+	cmdGenDocs.Flags.StringVar(&genDocsFlags.dir, "dir", "man", "Directory to write the man pages to.")
+}
+
+func genDocs(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if err := os.MkdirAll(genDocsFlags.dir, 0755); err != nil {
+		log.Print(err)
+		return ERROR_USAGE
+	}
+
+	// The pages are written in command order, leaving out the hidden
+	// commands and their subcommands, as the COMMANDS sections do.
+	type manPage struct {
+		name string
+		page []byte
+	}
+	pages := []manPage{{cliName, globalManPage()}}
+	var walk func(cmds []*Command)
+	walk = func(cmds []*Command) {
+		for _, cmd := range cmds {
+			if cmd.Hidden {
+				continue
+			}
+			pages = append(pages, manPage{manPageName(cmd), commandManPage(cmd)})
+			walk(cmd.Subcommands)
+		}
+	}
+	walk(commands)
+
+	for _, p := range pages {
+		path := filepath.Join(genDocsFlags.dir, p.name+".1")
+		if err := ioutil.WriteFile(path, p.page, 0644); err != nil {
+			log.Print(err)
+			return ERROR_USAGE
+		}
+		fmt.Fprintln(out, path)
+	}
+	out.Flush()
+	return OK
+}
+
+// manPageName returns the page name for cmd, such as updateservicectl-app-create.
+func manPageName(cmd *Command) string {
+	return cliName + "-" + strings.Join(strings.Fields(cmd.Name), "-")
+}
+
+// roffEscape escapes text for use in a roff document.
+func roffEscape(s string) string {
+	s = strings.Replace(s, `\`, `\e`, -1)
+	s = strings.Replace(s, "-", `\-`, -1)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		line = strings.TrimLeft(line, "\t ")
+		switch {
+		case line == "":
+			// a blank line is a paragraph break
+			line = ".PP"
+		case strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'"):
+			line = `\&` + line
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
+func writeManHeader(buf *bytes.Buffer, name, summary string) {
+	fmt.Fprintf(buf, ".TH %q \"1\" \"\" \"%s %s\" \"\"\n", strings.ToUpper(name), cliName, version.Version)
+	fmt.Fprintf(buf, ".SH NAME\n%s \\- %s\n", roffEscape(name), roffEscape(summary))
+}
+
+func writeManCommands(buf *bytes.Buffer, cmds []*Command) {
+	if len(cmds) == 0 {
+		return
+	}
+	buf.WriteString(".SH COMMANDS\n")
+	for _, cmd := range cmds {
+		if cmd.Hidden {
+			continue
+		}
+		fmt.Fprintf(buf, ".TP\n.B %s\n%s\n", roffEscape(cmd.Name), roffEscape(cmd.Summary))
+	}
+}
+
+func writeManOptions(buf *bytes.Buffer, title string, fs *flag.FlagSet) {
+	flags := getFlags(fs)
+	if len(flags) == 0 {
+		return
+	}
+	fmt.Fprintf(buf, ".SH %s\n", title)
+	for _, f := range flags {
+		prefix := "--"
+		if len(f.Name) == 1 {
+			prefix = "-"
+		}
+		fmt.Fprintf(buf, ".TP\n.B %s=%s\n%s\n", roffEscape(prefix+f.Name), roffEscape(f.DefValue), roffEscape(f.Usage))
+	}
+}
+
+func globalManPage() []byte {
+	var buf bytes.Buffer
+	writeManHeader(&buf, cliName, cliDescription)
+	fmt.Fprintf(&buf, ".SH SYNOPSIS\n.B %s\n[global options] <command> [command options] [arguments...]\n", cliName)
+	writeManCommands(&buf, commands)
+	writeManOptions(&buf, "GLOBAL OPTIONS", globalFlagSet)
+	return buf.Bytes()
+}
+
+func commandManPage(cmd *Command) []byte {
+	var buf bytes.Buffer
+	summary := cmd.Summary
+	if summary == "" {
+		summary = strings.SplitN(strings.TrimSpace(cmd.Description), "\n", 2)[0]
+	}
+	writeManHeader(&buf, manPageName(cmd), summary)
+	fmt.Fprintf(&buf, ".SH SYNOPSIS\n.B %s %s\n", cliName, roffEscape(cmd.Name))
+	if cmd.Usage != "" {
+		fmt.Fprintf(&buf, "%s\n", roffEscape(cmd.Usage))
+	}
+	if cmd.Description != "" {
+		fmt.Fprintf(&buf, ".SH DESCRIPTION\n%s\n", roffEscape(strings.TrimSpace(cmd.Description)))
+	}
+	writeManCommands(&buf, cmd.Subcommands)
+	writeManOptions(&buf, "OPTIONS", &cmd.Flags)
+	fmt.Fprintf(&buf, ".SH SEE ALSO\n.BR %s (1)\n", cliName)
+	return buf.Bytes()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// dryRun implements --dry-run for mutating commands. When the flag is set it
+// prints the request that would have been sent and returns true, and the
+// caller should return without calling the service.
+func dryRun(method, url string, body interface{}) bool {
+	if !globalFlags.DryRun {
+		return false
+	}
+
+	fmt.Fprintf(stdout, "%s %s\n", method, url)
+	if body != nil {
+		b, err := json.MarshalIndent(body, "", "  ")
+		if err != nil {
+			b = []byte(err.Error())
+		}
+		fmt.Fprintf(stdout, "%s\n", b)
+	}
+	return true
+}
+
+// apiURL returns the URL of an API resource below the service base path.
+// Each element is escaped and joined with a slash.
+func apiURL(basePath string, elem ...string) string {
+	for i, e := range elem {
+		elem[i] = url.PathEscape(e)
+	}
+	return basePath + strings.Join(elem, "/")
+}
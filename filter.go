@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// listFilter is a parsed --filter expression: comparisons of a field with a
+// value, joined by &&, such as
+//
+//	version==1.2.3 && status!=complete
+//
+// Fields are named by their JSON keys. Values may be double quoted to hold
+// spaces or &, and are compared as numbers when both sides are numeric.
+type listFilter struct {
+	terms []filterTerm
+}
+
+type filterTerm struct {
+	field  string
+	negate bool // != rather than ==
+	value  string
+	get    func(item interface{}) string
+}
+
+// parseFilter parses expr for filtering items of the struct pointer type of
+// sample. extra names fields that are computed rather than decoded, such as
+// an instance's status. An empty expr yields a nil filter, which matches
+// everything.
+func parseFilter(expr string, sample interface{}, extra map[string]func(interface{}) string) (*listFilter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+
+	fields := jsonFields(reflect.TypeOf(sample).Elem())
+	p := &filterParser{s: expr}
+	f := &listFilter{}
+	for {
+		t, err := p.term()
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %q: %v", expr, err)
+		}
+		if get, ok := extra[t.field]; ok {
+			t.get = get
+		} else if i, ok := fields[t.field]; ok {
+			t.get = fieldString(i)
+		} else {
+			return nil, fmt.Errorf("invalid filter %q: unknown field %q", expr, t.field)
+		}
+		f.terms = append(f.terms, t)
+
+		p.space()
+		if p.done() {
+			return f, nil
+		}
+		if !p.consume("&&") {
+			return nil, fmt.Errorf("invalid filter %q: expected && at %q", expr, p.s[p.pos:])
+		}
+	}
+}
+
+// jsonFields maps the JSON keys of the fields of struct type t to their
+// indexes.
+func jsonFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+	return fields
+}
+
+func fieldString(i int) func(item interface{}) string {
+	return func(item interface{}) string {
+		v := reflect.Indirect(reflect.ValueOf(item)).Field(i)
+		switch v.Kind() {
+		case reflect.Bool:
+			return strconv.FormatBool(v.Bool())
+		case reflect.Float32, reflect.Float64:
+			return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+		}
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+func (f *listFilter) match(item interface{}) bool {
+	if f == nil {
+		return true
+	}
+	for _, t := range f.terms {
+		if filterEqual(t.get(item), t.value) == t.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func filterEqual(a, b string) bool {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		return x == y
+	}
+	return a == b
+}
+
+// filter returns the elements of items, a slice, that f matches, as a slice
+// of the same type.
+func (f *listFilter) filter(items interface{}) interface{} {
+	if f == nil {
+		return items
+	}
+	rv := reflect.ValueOf(items)
+	matched := reflect.MakeSlice(rv.Type(), 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		if f.match(rv.Index(i).Interface()) {
+			matched = reflect.Append(matched, rv.Index(i))
+		}
+	}
+	return matched.Interface()
+}
+
+type filterParser struct {
+	s   string
+	pos int
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *filterParser) space() {
+	for !p.done() && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *filterParser) consume(tok string) bool {
+	if strings.HasPrefix(p.s[p.pos:], tok) {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) term() (filterTerm, error) {
+	var t filterTerm
+
+	p.space()
+	start := p.pos
+	for !p.done() && (p.s[p.pos] == '_' || unicode.IsLetter(rune(p.s[p.pos])) || unicode.IsDigit(rune(p.s[p.pos]))) {
+		p.pos++
+	}
+	t.field = p.s[start:p.pos]
+	if t.field == "" {
+		return t, fmt.Errorf("expected a field name at %q", p.s[start:])
+	}
+
+	p.space()
+	switch {
+	case p.consume("=="):
+	case p.consume("!="):
+		t.negate = true
+	default:
+		return t, fmt.Errorf("expected == or != after %s", t.field)
+	}
+
+	p.space()
+	if !p.done() && p.s[p.pos] == '"' {
+		end := strings.IndexByte(p.s[p.pos+1:], '"')
+		if end < 0 {
+			return t, fmt.Errorf("unterminated quoted value for %s", t.field)
+		}
+		t.value = p.s[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return t, nil
+	}
+	start = p.pos
+	for !p.done() && !unicode.IsSpace(rune(p.s[p.pos])) && !strings.HasPrefix(p.s[p.pos:], "&&") {
+		p.pos++
+	}
+	t.value = p.s[start:p.pos]
+	if t.value == "" {
+		return t, fmt.Errorf("expected a value for %s", t.field)
+	}
+	return t, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	update "github.com/coreos/updateservicectl/client/update/v1"
+)
+
+func TestParseFilter(t *testing.T) {
+	groups := []*update.Group{
+		{Id: "stable", ChannelId: "stable", UpdatePercent: 100},
+		{Id: "beta", ChannelId: "beta", UpdatePercent: 50, UpdatesPaused: true},
+		{Id: "canary", ChannelId: "beta", Label: "Canary & friends", UpdatePercent: 5},
+	}
+
+	tests := []struct {
+		expr string
+		want []string // IDs of the matching groups
+	}{
+		{"", []string{"stable", "beta", "canary"}},
+		{"channelId==beta", []string{"beta", "canary"}},
+		{"channelId != beta", []string{"stable"}},
+		{"channelId==beta && updatesPaused!=true", []string{"canary"}},
+		{"updatesPaused==false", []string{"stable", "canary"}},
+		{`label=="Canary & friends"`, []string{"canary"}},
+		{"updatePercent==50.0", []string{"beta"}},
+		{"updatePercent==1e2", []string{"stable"}},
+		{"id==nope", nil},
+	}
+
+	for _, tt := range tests {
+		f, err := parseFilter(tt.expr, (*update.Group)(nil), nil)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.expr, err)
+			continue
+		}
+		var got []string
+		for _, g := range f.filter(groups).([]*update.Group) {
+			got = append(got, g.Id)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: matched %v, want %v", tt.expr, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q: matched %v, want %v", tt.expr, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseFilterExtraFields(t *testing.T) {
+	f, err := parseFilter("status==error", (*update.ClientUpdate)(nil), instanceFilterFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.match(&update.ClientUpdate{EventType: "3", EventResult: "0"}) {
+		t.Error("status==error didn't match an instance whose update failed")
+	}
+	if f.match(&update.ClientUpdate{EventType: "3", EventResult: "2"}) {
+		t.Error("status==error matched a completed instance")
+	}
+}
+
+func TestParseFilterInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"channelId",
+		"channelId=beta",
+		"channelId==",
+		"==beta",
+		"nope==beta",
+		"channelId==beta &&",
+		"channelId==beta || id==stable",
+		"channelId==beta id==stable",
+		`label=="unterminated`,
+	} {
+		if _, err := parseFilter(expr, (*update.Group)(nil), nil); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
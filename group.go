@@ -1,11 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"net/http"
+	"os"
 	"strconv"
 	"text/tabwriter"
 
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+
 	"github.com/coreos/updateservicectl/client/update/v1"
 )
 
@@ -13,13 +18,26 @@ var (
 	groupFlags struct {
 		label         StringFlag
 		channel       StringFlag
-		appId         StringFlag
+		appId         UUIDFlag
 		groupId       StringFlag
 		oemBlacklist  StringFlag
 		start         int64
 		end           int64
 		resolution    int64
 		updatePercent float64
+		allApps       bool
+		all           bool
+		fields        string
+		sortBy        string
+		reverse       bool
+		filter        string
+		count         bool
+
+		// group create requires these, so it has values of its own
+		createAppId   UUIDFlag
+		createGroupId StringFlag
+		createChannel StringFlag
+		createLabel   StringFlag
 	}
 
 	cmdGroup = &Command{
@@ -27,11 +45,14 @@ var (
 		Summary: "Operations that manage groups in an application.",
 		Subcommands: []*Command{
 			cmdGroupList,
+			cmdGroupGet,
 			cmdGroupCreate,
 			cmdGroupDelete,
 			cmdGroupUpdate,
+			cmdGroupSetChannel,
 			cmdGroupPause,
 			cmdGroupUnpause,
+			cmdGroupResume,
 			cmdGroupEvents,
 			cmdGroupVersions,
 			cmdGroupPercent,
@@ -44,6 +65,12 @@ var (
 		Summary: `List all of the groups that exist including their label, token and update state.`,
 		Run:     groupList,
 	}
+	cmdGroupGet = &Command{
+		Name:    "group get",
+		Usage:   "[OPTION]...",
+		Summary: `Show the details of a group, including its rollout and instances by status.`,
+		Run:     groupGet,
+	}
 	cmdGroupCreate = &Command{
 		Name:    "group create",
 		Usage:   "[OPTION]...",
@@ -62,6 +89,12 @@ var (
 		Description: `Update an existing group.`,
 		Run:         groupUpdate,
 	}
+	cmdGroupSetChannel = &Command{
+		Name:    "group set-channel",
+		Usage:   "[OPTION]...",
+		Summary: `Change the channel a group follows.`,
+		Run:     groupSetChannel,
+	}
 	cmdGroupPause = &Command{
 		Name:    "group pause",
 		Usage:   "[OPTION]...",
@@ -74,6 +107,12 @@ var (
 		Summary: `Unpause a group's updates.`,
 		Run:     groupUnpause,
 	}
+	cmdGroupResume = &Command{
+		Name:    "group resume",
+		Usage:   "[OPTION]...",
+		Summary: `Resume a group's updates. Same as group unpause.`,
+		Run:     groupUnpause,
+	}
 	cmdGroupVersions = &Command{
 		Name:    "group versions",
 		Usage:   "[OPTION]...",
@@ -97,20 +136,41 @@ var (
 func init() {
 	cmdGroupList.Flags.Var(&groupFlags.appId, "app-id",
 		"Application containing the groups to list.")
+	cmdGroupList.Flags.BoolVar(&groupFlags.allApps, "all-apps", false,
+		"List the groups of every application instead of a single one.")
+	cmdGroupList.Flags.StringVar(&groupFlags.fields, "fields", groupListFields,
+		"Comma separated columns to show, in order: "+columnNames(groupColumns)+".")
+	cmdGroupList.Flags.StringVar(&groupFlags.sortBy, "sort-by", "",
+		"Comma separated columns to sort by, with --all-apps within each application. Ties keep the server's order.")
+	cmdGroupList.Flags.BoolVar(&groupFlags.reverse, "reverse", false,
+		"Reverse the --sort-by order.")
+	cmdGroupList.Flags.StringVar(&groupFlags.filter, "filter", "",
+		`Only list groups matching an expression such as 'channelId==beta && updatesPaused!=true'. Fields are named by their JSON keys.`)
+	cmdGroupList.Flags.BoolVar(&groupFlags.count, "count", false,
+		"Only print the number of matching groups.")
+
+	cmdGroupGet.Flags.Var(&groupFlags.appId, "app-id",
+		"Application containing the group.")
+	cmdGroupGet.Flags.Var(&groupFlags.groupId, "group-id",
+		"ID of the group to show.")
 
 	cmdGroupDelete.Flags.Var(&groupFlags.appId, "app-id",
 		"Application with group to delete.")
 	cmdGroupDelete.Flags.Var(&groupFlags.groupId, "group-id",
 		"ID of group to delete.")
 
-	cmdGroupCreate.Flags.Var(&groupFlags.appId, "app-id",
+	cmdGroupCreate.Flags.Var(&groupFlags.createAppId, "app-id",
 		"Application to add group to.")
-	cmdGroupCreate.Flags.Var(&groupFlags.groupId, "group-id",
+	groupFlags.createAppId.required = true
+	cmdGroupCreate.Flags.Var(&groupFlags.createGroupId, "group-id",
 		"ID for the new group.")
-	cmdGroupCreate.Flags.Var(&groupFlags.channel, "channel",
+	groupFlags.createGroupId.required = true
+	cmdGroupCreate.Flags.Var(&groupFlags.createChannel, "channel",
 		"Channel to associate with the group.")
-	cmdGroupCreate.Flags.Var(&groupFlags.label, "label",
+	groupFlags.createChannel.required = true
+	cmdGroupCreate.Flags.Var(&groupFlags.createLabel, "label",
 		"Label describing the new group.")
+	groupFlags.createLabel.required = true
 
 	cmdGroupUpdate.Flags.Var(&groupFlags.appId, "app-id",
 		"Application containing the group to update.")
@@ -123,15 +183,35 @@ func init() {
 	cmdGroupUpdate.Flags.Var(&groupFlags.oemBlacklist, "oem-blacklist",
 		"Comma-separated list of OEMs to exclude from updates.")
 
+	cmdGroupSetChannel.Flags.Var(&groupFlags.appId, "app-id",
+		"Application containing the group.")
+	cmdGroupSetChannel.Flags.Var(&groupFlags.groupId, "group-id",
+		"ID for the group.")
+	cmdGroupSetChannel.Flags.Var(&groupFlags.channel, "channel",
+		"Channel the group should follow.")
+
 	cmdGroupPause.Flags.Var(&groupFlags.appId, "app-id",
 		"Application containing the group to pause.")
 	cmdGroupPause.Flags.Var(&groupFlags.groupId, "group-id",
 		"ID for the group.")
+	cmdGroupPause.Flags.BoolVar(&groupFlags.all, "all", false,
+		"Pause every group of the application instead of --group-id.")
 
 	cmdGroupUnpause.Flags.Var(&groupFlags.appId, "app-id",
 		"Application containing the group to unpause.")
 	cmdGroupUnpause.Flags.Var(&groupFlags.groupId, "group-id",
 		"ID for the group.")
+	addWaitFlags(&cmdGroupUnpause.Flags)
+	cmdGroupUnpause.Flags.BoolVar(&groupFlags.all, "all", false,
+		"Unpause every group of the application instead of --group-id.")
+
+	cmdGroupResume.Flags.Var(&groupFlags.appId, "app-id",
+		"Application containing the group to resume.")
+	cmdGroupResume.Flags.Var(&groupFlags.groupId, "group-id",
+		"ID for the group.")
+	addWaitFlags(&cmdGroupResume.Flags)
+	cmdGroupResume.Flags.BoolVar(&groupFlags.all, "all", false,
+		"Resume every group of the application instead of --group-id.")
 
 	cmdGroupVersions.Flags.Var(&groupFlags.appId, "app-id",
 		"Application containing the group.")
@@ -159,8 +239,14 @@ func init() {
 		"Application containing the group.")
 	cmdGroupPercent.Flags.Var(&groupFlags.groupId, "group-id",
 		"ID for the group.")
+	addWaitFlags(&cmdGroupPercent.Flags)
 	cmdGroupPercent.Flags.Float64Var(&groupFlags.updatePercent,
 		"update-percent", -1, "Percentage of machines to update")
+
+	addAppLabelFlag(&groupFlags.createAppId, cmdGroupCreate)
+	addAppLabelFlag(&groupFlags.appId, cmdGroupList, cmdGroupGet, cmdGroupDelete,
+		cmdGroupUpdate, cmdGroupSetChannel, cmdGroupPause, cmdGroupUnpause, cmdGroupResume,
+		cmdGroupVersions, cmdGroupEvents, cmdGroupPercent)
 }
 
 const groupHeader = "Label\tApp\tChannel\tId\tUpdates Paused\tPercent\tRollout Active\n"
@@ -172,28 +258,187 @@ func formatGroup(group *update.Group) string {
 		group.UpdatePercent, strconv.FormatBool(group.RolloutActive))
 }
 
-func groupList(args []string, service *update.Service, out *tabwriter.Writer) int {
+// groupColumns are the columns group list can show. groupListFields selects
+// the same columns as groupHeader.
+var groupColumns = []tableColumn{
+	{"label", "Label", func(v interface{}) string { return v.(*update.Group).Label }},
+	{"app", "App", func(v interface{}) string { return v.(*update.Group).AppId }},
+	{"channel", "Channel", func(v interface{}) string { return v.(*update.Group).ChannelId }},
+	{"id", "Id", func(v interface{}) string { return v.(*update.Group).Id }},
+	{"updates-paused", "Updates Paused", func(v interface{}) string { return strconv.FormatBool(v.(*update.Group).UpdatesPaused) }},
+	{"percent", "Percent", func(v interface{}) string { return fmt.Sprint(v.(*update.Group).UpdatePercent) }},
+	{"rollout-active", "Rollout Active", func(v interface{}) string { return strconv.FormatBool(v.(*update.Group).RolloutActive) }},
+	{"oem-blacklist", "OEM Blacklist", func(v interface{}) string { return v.(*update.Group).OemBlacklist }},
+}
+
+const groupListFields = "label,app,channel,id,updates-paused,percent,rollout-active"
+
+func groupList(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	columns, err := selectColumns(groupColumns, groupFlags.fields)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ERROR_USAGE
+	}
+	var sortBy []tableColumn
+	if groupFlags.sortBy != "" {
+		if sortBy, err = selectColumns(groupColumns, groupFlags.sortBy); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ERROR_USAGE
+		}
+	}
+	filter, err := parseFilter(groupFlags.filter, (*update.Group)(nil), nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ERROR_USAGE
+	}
+
+	if groupFlags.allApps {
+		if groupFlags.appId.Get() != nil {
+			fmt.Fprintln(os.Stderr, "--app-id and --all-apps can't be used together")
+			return ERROR_USAGE
+		}
+		return groupListAllApps(ctx, service, out, columns, sortBy, filter)
+	}
 	if groupFlags.appId.Get() == nil {
+		fmt.Fprintln(os.Stderr, "either --app-id or --all-apps is required")
 		return ERROR_USAGE
 	}
 
 	listCall := service.Group.List(groupFlags.appId.String())
-	list, err := listCall.Do()
+	list, err := listCall.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	list.Items = filter.filter(list.Items).([]*update.Group)
+	sortByColumns(sortBy, list.Items, groupFlags.reverse)
+
+	if groupFlags.count {
+		return printCount(out, list.Items)
 	}
 
-	fmt.Fprint(out, groupHeader)
-	for _, group := range list.Items {
-		fmt.Fprintf(out, "%s", formatGroup(group))
+	if structuredOutput() {
+		return printStructured(out, list.Items)
 	}
 
+	if globalFlags.Quiet {
+		return printIDs(out, list.Items, "Id")
+	}
+
+	printColumns(out, columns, list.Items)
+
 	out.Flush()
 	return OK
 }
 
-func groupEvents(args []string, service *update.Service, out *tabwriter.Writer) int {
+// groupListAllApps lists the groups of every application, fetching them
+// concurrently and printing them by app ID with the app label in front.
+func groupListAllApps(ctx context.Context, service *update.Service, out *tabwriter.Writer, columns, sortBy []tableColumn, filter *listFilter) int {
+	apps, err := listAppsByID(ctx, service)
+	if err != nil {
+		fatal(err)
+	}
+
+	groups := make([][]*update.Group, len(apps))
+	err = parallel(ctx, len(apps), func(ctx context.Context, i int) error {
+		list, err := service.Group.List(apps[i].Id).Context(ctx).Do()
+		if err != nil {
+			return err
+		}
+		groups[i] = filter.filter(list.Items).([]*update.Group)
+		sortByColumns(sortBy, groups[i], groupFlags.reverse)
+		return nil
+	})
+	if err != nil {
+		fatal(err)
+	}
+
+	var all []*update.Group
+	for _, g := range groups {
+		all = append(all, g...)
+	}
+
+	if groupFlags.count {
+		return printCount(out, all)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, all)
+	}
+
+	if globalFlags.Quiet {
+		return printIDs(out, all, "Id")
+	}
+
+	label := make(map[string]string, len(apps))
+	for _, app := range apps {
+		label[app.Id] = app.Label
+	}
+	columns = append([]tableColumn{
+		{"application", "Application", func(v interface{}) string { return label[v.(*update.Group).AppId] }},
+	}, columns...)
+	printColumns(out, columns, all)
+
+	out.Flush()
+	return OK
+}
+
+func groupGet(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if groupFlags.appId.Get() == nil ||
+		groupFlags.groupId.Get() == nil {
+		return ERROR_USAGE
+	}
+	appId, groupId := groupFlags.appId.String(), groupFlags.groupId.String()
+
+	group, err := service.Group.Get(appId, groupId).Context(ctx).Do()
+	if err != nil {
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, group)
+	}
+
+	// A group that never had a rollout policy set has none to show.
+	rollout, err := service.Group.Rollout.Get(appId, groupId).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		rollout, err = &update.Rollout{}, nil
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	counts, total, err := countInstances(ctx, service, appId, groupId, instanceStatus)
+	if err != nil {
+		fatal(err)
+	}
+
+	fmt.Fprintf(out, "Id:\t%s\n", group.Id)
+	fmt.Fprintf(out, "Label:\t%s\n", group.Label)
+	fmt.Fprintf(out, "App:\t%s\n", group.AppId)
+	fmt.Fprintf(out, "Channel:\t%s\n", group.ChannelId)
+	fmt.Fprintf(out, "Updates Enabled:\t%t\n", !group.UpdatesPaused)
+	fmt.Fprintf(out, "Update Percent:\t%v\n", group.UpdatePercent)
+	fmt.Fprintf(out, "OEM Blacklist:\t%s\n", group.OemBlacklist)
+	fmt.Fprintf(out, "Rollout Active:\t%t\n", group.RolloutActive)
+	if len(rollout.Rollout) == 0 {
+		fmt.Fprintf(out, "Rollout:\tnone\n")
+	}
+	for i, frame := range rollout.Rollout {
+		fmt.Fprintf(out, "Rollout Frame %d:\t%v%% for %ds\n", i, frame.Percent, frame.Duration)
+	}
+	fmt.Fprintf(out, "Instances:\t%d\n", total)
+	for _, status := range []string{instanceComplete, instanceUpdating, instanceError} {
+		fmt.Fprintf(out, "  %s:\t%d\n", status, counts[status])
+	}
+
+	out.Flush()
+	return OK
+}
+
+func groupEvents(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if groupFlags.appId.Get() == nil || groupFlags.groupId.Get() == nil {
 		return ERROR_USAGE
 	}
@@ -205,13 +450,17 @@ func groupEvents(args []string, service *update.Service, out *tabwriter.Writer)
 		groupFlags.end,
 	)
 	call.Resolution(groupFlags.resolution)
-	list, err := call.Do()
+	list, err := call.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	fmt.Fprintln(out, "Version\tType\tResult\tTimestamp\tCount")
+	if structuredOutput() {
+		return printStructured(out, list.Items)
+	}
+
+	printHeader(out, "Version\tType\tResult\tTimestamp\tCount\n")
 	for _, i := range list.Items {
 		for _, j := range i.Values {
 			fmt.Fprintf(out, "%s\t%s\t%s\t%d\t%d\n",
@@ -222,7 +471,7 @@ func groupEvents(args []string, service *update.Service, out *tabwriter.Writer)
 	return OK
 }
 
-func groupVersions(args []string, service *update.Service, out *tabwriter.Writer) int {
+func groupVersions(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if groupFlags.appId.Get() == nil || groupFlags.groupId.Get() == nil {
 		return ERROR_USAGE
 	}
@@ -234,13 +483,17 @@ func groupVersions(args []string, service *update.Service, out *tabwriter.Writer
 		groupFlags.end,
 	)
 	call.Resolution(groupFlags.resolution)
-	list, err := call.Do()
+	list, err := call.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	fmt.Fprintln(out, "Version\tTimestamp\tCount")
+	if structuredOutput() {
+		return printStructured(out, list.Items)
+	}
+
+	printHeader(out, "Version\tTimestamp\tCount\n")
 	for _, i := range list.Items {
 		for _, j := range i.Values {
 			fmt.Fprintf(out, "%s\t%d\t%d\n",
@@ -251,99 +504,234 @@ func groupVersions(args []string, service *update.Service, out *tabwriter.Writer
 	return OK
 }
 
-func groupCreate(args []string, service *update.Service, out *tabwriter.Writer) int {
-	if groupFlags.appId.Get() == nil ||
-		groupFlags.groupId.Get() == nil ||
-		groupFlags.channel.Get() == nil {
-		return ERROR_USAGE
-	}
-
+func groupCreate(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	group := &update.Group{
-		ChannelId: groupFlags.channel.String(),
-		Id:        groupFlags.groupId.String(),
-		Label:     groupFlags.label.String(),
+		ChannelId: groupFlags.createChannel.String(),
+		Id:        groupFlags.createGroupId.String(),
+		Label:     groupFlags.createLabel.String(),
+	}
+	if dryRun("POST", apiURL(service.BasePath, "apps", groupFlags.createAppId.String(), "groups"), group) {
+		return OK
 	}
-	call := service.Group.Insert(groupFlags.appId.String(), group)
-	group, err := call.Do()
+	call := service.Group.Insert(groupFlags.createAppId.String(), group)
+	group, err := call.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	fmt.Fprint(out, groupHeader)
+	if structuredOutput() {
+		return printStructured(out, group)
+	}
+
+	printHeader(out, groupHeader)
 	fmt.Fprintf(out, "%s", formatGroup(group))
 	out.Flush()
 	return OK
 }
 
-func groupDelete(args []string, service *update.Service, out *tabwriter.Writer) int {
+func groupDelete(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if groupFlags.appId.Get() == nil ||
 		groupFlags.groupId.Get() == nil {
 		return ERROR_USAGE
 	}
 
+	if dryRun("DELETE", apiURL(service.BasePath, "apps", groupFlags.appId.String(), "groups", groupFlags.groupId.String()), nil) {
+		return OK
+	}
 	call := service.Group.Delete(groupFlags.appId.String(), groupFlags.groupId.String())
-	group, err := call.Do()
+	group, err := call.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, group)
 	}
 
-	fmt.Fprint(out, groupHeader)
+	printHeader(out, groupHeader)
 	fmt.Fprintf(out, "%s", formatGroup(group))
 	out.Flush()
 	return OK
 }
 
-func groupPause(args []string, service *update.Service, out *tabwriter.Writer) int {
-	return setUpdatesPaused(service, out, true)
+func groupPause(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	return setUpdatesPaused(ctx, service, out, true)
 }
 
-func groupUnpause(args []string, service *update.Service, out *tabwriter.Writer) int {
-	return setUpdatesPaused(service, out, false)
+func groupUnpause(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	return setUpdatesPaused(ctx, service, out, false)
 }
 
 // Helper function for pause/unpause-group commands
-func setUpdatesPaused(service *update.Service, out *tabwriter.Writer, paused bool) int {
+func setUpdatesPaused(ctx context.Context, service *update.Service, out *tabwriter.Writer, paused bool) int {
+	if !checkWaitFlags() {
+		return ERROR_USAGE
+	}
+	if groupFlags.all && waitFlags.wait {
+		fmt.Fprintln(os.Stderr, "--wait can't be used with --all")
+		return ERROR_USAGE
+	}
+	if groupFlags.all {
+		if groupFlags.appId.Get() == nil || groupFlags.groupId.Get() != nil {
+			fmt.Fprintln(os.Stderr, "--all requires --app-id and can't be used with --group-id")
+			return ERROR_USAGE
+		}
+		return setAllUpdatesPaused(ctx, service, out, paused)
+	}
 	if groupFlags.appId.Get() == nil ||
 		groupFlags.groupId.Get() == nil {
 		return ERROR_USAGE
 	}
 
 	call := service.Group.Get(groupFlags.appId.String(), groupFlags.groupId.String())
-	group, err := call.Do()
+	group, err := call.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	// Already in the requested state; nothing to change.
+	if group.UpdatesPaused == paused {
+		return waitAfter(ctx, service, group, printGroup(out, group))
 	}
 
 	group.UpdatesPaused = paused
+	group.ForceSendFields = []string{"UpdatesPaused"}
 
+	if dryRun("PATCH", apiURL(service.BasePath, "apps", groupFlags.appId.String(), "groups", groupFlags.groupId.String()), group) {
+		return OK
+	}
 	updateCall := service.Group.Patch(groupFlags.appId.String(), groupFlags.groupId.String(), group)
-	group, err = updateCall.Do()
+	group, err = updateCall.Context(ctx).Do()
+
+	if err != nil {
+		fatal(err)
+	}
+
+	return waitAfter(ctx, service, group, printGroup(out, group))
+}
+
+// waitAfter implements --wait for a group once the command's result has been
+// printed with exit code code, waiting for the version of the group's
+// channel.
+func waitAfter(ctx context.Context, service *update.Service, group *update.Group, code int) int {
+	if code != OK || !waitFlags.wait {
+		return code
+	}
+	channel, code := findChannel(ctx, service, group.AppId, group.ChannelId)
+	if channel == nil {
+		return code
+	}
+	return waitForRollout(ctx, service, group.AppId, []string{group.Id}, channel.Version)
+}
+
+// groupPauseResult reports the outcome of pausing or resuming one group with
+// --all. Error is empty if the group is now in the requested state.
+type groupPauseResult struct {
+	GroupId       string `json:"groupId"`
+	UpdatesPaused bool   `json:"updatesPaused"`
+	Changed       bool   `json:"changed"`
+	Error         string `json:"error,omitempty"`
+}
+
+// setAllUpdatesPaused pauses or resumes every group of an app. A failure on
+// one group doesn't stop the others, so the results say which ones succeeded.
+func setAllUpdatesPaused(ctx context.Context, service *update.Service, out *tabwriter.Writer, paused bool) int {
+	appId := groupFlags.appId.String()
+	list, err := service.Group.List(appId).Context(ctx).Do()
+	if err != nil {
+		fatal(err)
+	}
+	groups := list.Items
+
+	if globalFlags.DryRun {
+		for _, group := range groups {
+			if group.UpdatesPaused != paused {
+				group.UpdatesPaused = paused
+				group.ForceSendFields = []string{"UpdatesPaused"}
+				dryRun("PATCH", apiURL(service.BasePath, "apps", appId, "groups", group.Id), group)
+			}
+		}
+		return OK
+	}
+
+	results := make([]groupPauseResult, len(groups))
+	err = parallel(ctx, len(groups), func(ctx context.Context, i int) error {
+		group := groups[i]
+		results[i] = groupPauseResult{GroupId: group.Id, UpdatesPaused: group.UpdatesPaused}
+		if group.UpdatesPaused == paused {
+			return nil
+		}
 
+		group.UpdatesPaused = paused
+		group.ForceSendFields = []string{"UpdatesPaused"}
+		if _, err := service.Group.Patch(appId, group.Id, group).Context(ctx).Do(); err != nil {
+			results[i].Error = redact(err.Error())
+			return nil
+		}
+		results[i].UpdatesPaused = paused
+		results[i].Changed = true
+		return nil
+	})
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	fmt.Fprint(out, groupHeader)
+	code := OK
+	for _, r := range results {
+		if r.Error != "" {
+			code = ERROR_API
+		}
+	}
+
+	if structuredOutput() {
+		if c := printStructured(out, results); c != OK {
+			return c
+		}
+		return code
+	}
+
+	printHeader(out, "Group\tUpdates Paused\tResult\n")
+	for _, r := range results {
+		result := "unchanged"
+		switch {
+		case r.Error != "":
+			result = "failed: " + r.Error
+		case r.Changed:
+			result = "updated"
+		}
+		fmt.Fprintf(out, "%s\t%t\t%s\n", r.GroupId, r.UpdatesPaused, result)
+	}
+
+	out.Flush()
+	return code
+}
+
+func printGroup(out *tabwriter.Writer, group *update.Group) int {
+	if structuredOutput() {
+		return printStructured(out, group)
+	}
+
+	printHeader(out, groupHeader)
 	fmt.Fprintf(out, "%s", formatGroup(group))
 
 	out.Flush()
 	return OK
 }
 
-func groupUpdate(args []string, service *update.Service, out *tabwriter.Writer) int {
+func groupUpdate(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if groupFlags.appId.Get() == nil ||
 		groupFlags.groupId.Get() == nil {
 		return ERROR_USAGE
 	}
 
 	call := service.Group.Get(groupFlags.appId.String(), groupFlags.groupId.String())
-	group, err := call.Do()
+	group, err := call.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	if groupFlags.label.Get() != nil {
@@ -356,26 +744,74 @@ func groupUpdate(args []string, service *update.Service, out *tabwriter.Writer)
 		group.OemBlacklist = groupFlags.oemBlacklist.String()
 	}
 
+	if dryRun("PATCH", apiURL(service.BasePath, "apps", groupFlags.appId.String(), "groups", groupFlags.groupId.String()), group) {
+		return OK
+	}
 	updateCall := service.Group.Patch(groupFlags.appId.String(), groupFlags.groupId.String(), group)
-	group, err = updateCall.Do()
+	group, err = updateCall.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	fmt.Fprint(out, groupHeader)
+	if structuredOutput() {
+		return printStructured(out, group)
+	}
+
+	printHeader(out, groupHeader)
 	fmt.Fprintf(out, "%s", formatGroup(group))
 
 	out.Flush()
 	return OK
 }
 
-func groupPercent(args []string, service *update.Service, out *tabwriter.Writer) int {
+func groupSetChannel(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if groupFlags.appId.Get() == nil ||
+		groupFlags.groupId.Get() == nil ||
+		groupFlags.channel.Get() == nil {
+		return ERROR_USAGE
+	}
+	appId, groupId := groupFlags.appId.String(), groupFlags.groupId.String()
+
+	group, err := service.Group.Get(appId, groupId).Context(ctx).Do()
+	if err != nil {
+		fatal(err)
+	}
+	if _, code := findChannel(ctx, service, appId, groupFlags.channel.String()); code != OK {
+		return code
+	}
+
+	before := group.ChannelId
+	group.ChannelId = groupFlags.channel.String()
+	if before != group.ChannelId {
+		if dryRun("PATCH", apiURL(service.BasePath, "apps", appId, "groups", groupId), group) {
+			return OK
+		}
+		group, err = service.Group.Patch(appId, groupId, group).Context(ctx).Do()
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	if structuredOutput() {
+		return printStructured(out, group)
+	}
+
+	printHeader(out, "Group\tOld Channel\tNew Channel\n")
+	fmt.Fprintf(out, "%s\t%s\t%s\n", group.Id, before, group.ChannelId)
+	out.Flush()
+	return OK
+}
+
+func groupPercent(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if groupFlags.appId.Get() == nil ||
 		groupFlags.groupId.Get() == nil ||
 		groupFlags.updatePercent == -1 {
 		return ERROR_USAGE
 	}
+	if !checkWaitFlags() {
+		return ERROR_USAGE
+	}
 
 	groupPercent := &update.GroupPercent{
 		AppId:         groupFlags.appId.String(),
@@ -383,14 +819,30 @@ func groupPercent(args []string, service *update.Service, out *tabwriter.Writer)
 		UpdatePercent: groupFlags.updatePercent,
 	}
 
+	if dryRun("POST", apiURL(service.BasePath, "apps", groupFlags.appId.String(), "groups", groupFlags.groupId.String()), groupPercent) {
+		return OK
+	}
 	setCall := service.Group.Percent.Set(groupFlags.appId.String(), groupFlags.groupId.String(), groupPercent)
-	groupPercent, err := setCall.Do()
+	groupPercent, err := setCall.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	fmt.Fprintf(out, "update percent set to %f\n", groupPercent.UpdatePercent)
-	out.Flush()
-	return OK
+	code := OK
+	if structuredOutput() {
+		code = printStructured(out, groupPercent)
+	} else {
+		fmt.Fprintf(out, "update percent set to %f\n", groupPercent.UpdatePercent)
+		out.Flush()
+	}
+	if code != OK || !waitFlags.wait {
+		return code
+	}
+
+	group, err := service.Group.Get(groupFlags.appId.String(), groupFlags.groupId.String()).Context(ctx).Do()
+	if err != nil {
+		fatal(err)
+	}
+	return waitAfter(ctx, service, group, OK)
 }
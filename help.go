@@ -7,6 +7,8 @@ import (
 	"text/tabwriter"
 	"text/template"
 
+	"golang.org/x/net/context"
+
 	"github.com/coreos/updateservicectl/client/update/v1"
 	"github.com/coreos/updateservicectl/version"
 )
@@ -18,6 +20,7 @@ var (
 		Usage:       "[COMMAND]",
 		Description: "Show a list of commands or detailed help for one command",
 		Run:         runHelp,
+		NoAuth:      true,
 	}
 
 	globalUsageTemplate  *template.Template
@@ -34,6 +37,12 @@ var (
 			}
 			return fmt.Sprintf("\t%s%s=%s\t%s", prefix, name, defvalue, usage)
 		},
+		"flagUsage": func(f *flag.Flag) string {
+			if r, ok := f.Value.(interface{ isRequired() bool }); ok && r.isRequired() {
+				return f.Usage + " (required)"
+			}
+			return f.Usage
+		},
 	}
 )
 
@@ -48,12 +57,16 @@ USAGE:
 VERSION:
 {{printf "\t%s" .Version}}
 
-COMMANDS:{{range .Commands}}
-{{printf "\t%s\t%s" .Name .Summary}}{{end}}
+COMMANDS:{{range .Commands}}{{if not .Hidden}}
+{{printf "\t%s\t%s" .Name .Summary}}{{end}}{{end}}
 
 GLOBAL OPTIONS:{{range .Flags}}
 {{printOption .Name .DefValue .Usage}}{{end}}
 
+Global options not given on the command line, other than --help, --version,
+--config and --out-file, are read from UPDATECTL_<OPTION> environment
+variables, for example UPDATECTL_NO_HEADERS=true.
+
 Run "{{.Executable}} <command> --help" for more details on a specific command.
 `[1:]))
 	commandUsageTemplate = template.Must(template.New("command_usage").Funcs(templFuncs).Parse(`
@@ -69,13 +82,14 @@ DESCRIPTION:
 {{if .Cmd.Subcommands}}COMMANDS:{{range .Cmd.Subcommands}}
 {{printf "\t%s\t%s" .Name .Summary}}{{end}}
 {{end}}
-{{if .CmdFlags}}OPTIONS:{{range .CmdFlags}}
-{{printOption .Name .DefValue .Usage}}{{end}}
-{{end}}For help on global options run "{{.Executable}} --help"
+OPTIONS:{{range .CmdFlags}}
+{{printOption .Name .DefValue (flagUsage .)}}{{end}}
+{{printOption "help" "false" "Print this help and exit."}}
+For help on global options run "{{.Executable}} --help"
 `[1:]))
 }
 
-func runHelp(args []string, service *update.Service, out *tabwriter.Writer) int {
+func runHelp(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if len(args) < 1 {
 		printGlobalUsage()
 		return OK
@@ -91,7 +105,8 @@ func runHelp(args []string, service *update.Service, out *tabwriter.Writer) int
 	}
 
 	if cmd == nil {
-		fmt.Println("Unrecognized command:", args[0])
+		fmt.Fprintln(out, "Unrecognized command:", args[0])
+		out.Flush()
 		return ERROR_NO_COMMAND
 	}
 
@@ -2,17 +2,23 @@ package main
 
 import (
 	"bytes"
+	"encoding/csv"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
-	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
 
 	"github.com/coreos/go-omaha/omaha"
 	"github.com/pborman/uuid"
+	"golang.org/x/net/context"
 
 	update "github.com/coreos/updateservicectl/client/update/v1"
 )
@@ -20,9 +26,19 @@ import (
 var (
 	instanceFlags struct {
 		groupId       StringFlag
-		appId         StringFlag
+		appId         UUIDFlag
+		appIds        UUIDListFlag
+		fakeGroupId   StringFlag
+		fakeAppId     UUIDFlag
 		start         int64
 		end           int64
+		limit         int64
+		offset        int64
+		status        string
+		listVersion   string
+		byStatus      bool
+		format        string
+		crlf          bool
 		verbose       bool
 		clientsPerApp int
 		minSleep      int
@@ -32,6 +48,17 @@ var (
 		pingOnly      int
 		version       string
 		forceUpdate   bool
+		instanceId    string
+		eventsLimit   int
+		fields        string
+		sortBy        string
+		reverse       bool
+		filter        string
+		count         bool
+		match         string
+		regex         bool
+		relative      bool
+		absolute      bool
 	}
 
 	cmdInstance = &Command{
@@ -41,6 +68,9 @@ var (
 		Subcommands: []*Command{
 			cmdInstanceListUpdates,
 			cmdInstanceListAppVersions,
+			cmdInstanceSummary,
+			cmdInstanceEvents,
+			cmdInstanceFind,
 			cmdInstanceFake,
 		},
 	}
@@ -59,25 +89,74 @@ var (
 		Run:         instanceListAppVersions,
 	}
 
+	cmdInstanceSummary = &Command{
+		Name:        "instance summary",
+		Usage:       "[OPTION]...",
+		Description: "Counts instances by version, or by status with --by-status, largest first.",
+		Run:         instanceSummary,
+	}
+
+	cmdInstanceFind = &Command{
+		Name:        "instance find",
+		Usage:       "[OPTION]...",
+		Description: "Lists the instances of an application whose client ID contains a string, ignoring case.",
+		Run:         instanceFind,
+	}
+
+	cmdInstanceEvents = &Command{
+		Name:        "instance events",
+		Usage:       "[OPTION]...",
+		Description: "Shows the event log of a single instance, oldest first.",
+		Run:         instanceEvents,
+	}
+
 	cmdInstanceFake = &Command{
 		Name:        "instance fake",
 		Usage:       "[OPTION]...",
 		Description: "Simulate multiple fake instances.",
 		Run:         instanceFake,
+		NoAuth:      true,
 	}
 )
 
 func init() {
 	cmdInstanceListUpdates.Flags.Var(&instanceFlags.groupId, "group-id", "Group id")
-	cmdInstanceListUpdates.Flags.Var(&instanceFlags.appId, "app-id", "App id")
+	cmdInstanceListUpdates.Flags.Var(&instanceFlags.appIds, "app-id", "App id. Repeat to list the instances of several applications, with an application column")
 	cmdInstanceListUpdates.Flags.Int64Var(&instanceFlags.start, "start", 0, "Start date filter")
 	cmdInstanceListUpdates.Flags.Int64Var(&instanceFlags.end, "end", 0, "End date filter")
+	cmdInstanceListUpdates.Flags.Int64Var(&instanceFlags.limit, "limit", 100, "Maximum number of instances to list, 0 for the server's default")
+	cmdInstanceListUpdates.Flags.Int64Var(&instanceFlags.offset, "offset", 0, "Number of instances to skip before listing")
+	cmdInstanceListUpdates.Flags.StringVar(&instanceFlags.status, "status", "", "Only list instances whose last event has one of these comma separated statuses: complete, error or updating. Filtered locally, after --limit is applied")
+	cmdInstanceListUpdates.Flags.StringVar(&instanceFlags.listVersion, "version", "", "Only list instances reporting this version. Filtered by the server")
+	cmdInstanceListUpdates.Flags.StringVar(&instanceFlags.format, "format", "table", "Output format: table, wide or csv. wide adds the status and last event columns to the table")
+	cmdInstanceListUpdates.Flags.BoolVar(&instanceFlags.crlf, "crlf", false, "Terminate csv lines with CRLF")
+	cmdInstanceListUpdates.Flags.StringVar(&instanceFlags.fields, "fields", instanceListFields, "Comma separated columns to show, in order: "+columnNames(instanceColumns))
+	cmdInstanceListUpdates.Flags.StringVar(&instanceFlags.sortBy, "sort-by", "", "Comma separated columns to sort by. Ties keep the server's order")
+	cmdInstanceListUpdates.Flags.BoolVar(&instanceFlags.reverse, "reverse", false, "Reverse the --sort-by order")
+	cmdInstanceListUpdates.Flags.StringVar(&instanceFlags.filter, "filter", "", "Only list instances matching an expression such as 'version==1.2.3 && status!=complete'. Fields are named by their JSON keys, plus status. Filtered locally, after --limit is applied")
+	cmdInstanceListUpdates.Flags.BoolVar(&instanceFlags.count, "count", false, "Only print the number of matching instances")
+	cmdInstanceListUpdates.Flags.BoolVar(&instanceFlags.relative, "relative", false, "Show last seen times in table output as ages, such as 3m ago. The default on a terminal")
+	cmdInstanceListUpdates.Flags.BoolVar(&instanceFlags.absolute, "absolute", false, "Show last seen times as reported by the server")
 
 	cmdInstanceListAppVersions.Flags.Var(&instanceFlags.groupId, "group-id", "Group id")
 	cmdInstanceListAppVersions.Flags.Var(&instanceFlags.appId, "app-id", "App id")
 	cmdInstanceListAppVersions.Flags.Int64Var(&instanceFlags.start, "start", 0, "Start date filter")
 	cmdInstanceListAppVersions.Flags.Int64Var(&instanceFlags.end, "end", 0, "End date filter")
 
+	cmdInstanceSummary.Flags.Var(&instanceFlags.groupId, "group-id", "Group id")
+	cmdInstanceSummary.Flags.Var(&instanceFlags.appId, "app-id", "App id")
+	cmdInstanceSummary.Flags.BoolVar(&instanceFlags.byStatus, "by-status", false, "Count instances by status instead of version")
+
+	cmdInstanceEvents.Flags.StringVar(&instanceFlags.instanceId, "instance-id", "", "Instance (client) id")
+	cmdInstanceEvents.Flags.Var(&instanceFlags.groupId, "group-id", "Only show events reported while in this group")
+	cmdInstanceEvents.Flags.IntVar(&instanceFlags.eventsLimit, "limit", 0, "Show at most this many of the latest events, 0 for all")
+	cmdInstanceEvents.Flags.BoolVar(&instanceFlags.reverse, "reverse", false, "Show the newest events first")
+
+	cmdInstanceFind.Flags.Var(&instanceFlags.appId, "app-id", "Application to search")
+	cmdInstanceFind.Flags.Var(&instanceFlags.groupId, "group-id", "Only search this group")
+	cmdInstanceFind.Flags.StringVar(&instanceFlags.match, "match", "", "String to look for in client IDs")
+	cmdInstanceFind.Flags.BoolVar(&instanceFlags.regex, "regex", false, "Treat --match as a regular expression")
+
 	cmdInstanceFake.Flags.BoolVar(&instanceFlags.verbose, "verbose", false, "Print out the request bodies")
 	cmdInstanceFake.Flags.IntVar(&instanceFlags.clientsPerApp, "clients-per-app", 20, "Number of fake fents per appid.")
 	cmdInstanceFake.Flags.IntVar(&instanceFlags.minSleep, "min-sleep", 1, "Minimum time between update checks.")
@@ -86,40 +165,536 @@ func init() {
 	cmdInstanceFake.Flags.StringVar(&instanceFlags.OEM, "oem", "fakeclient", "oem to report")
 	// simulate reboot lock.
 	cmdInstanceFake.Flags.IntVar(&instanceFlags.pingOnly, "ping-only", 0, "halt update and just send ping requests this many times.")
-	cmdInstanceFake.Flags.Var(&instanceFlags.appId, "app-id", "Application ID to update.")
-	instanceFlags.appId.required = true
-	cmdInstanceFake.Flags.Var(&instanceFlags.groupId, "group-id", "Group ID to update.")
-	instanceFlags.groupId.required = true
+	cmdInstanceFake.Flags.Var(&instanceFlags.fakeAppId, "app-id", "Application ID to update.")
+	instanceFlags.fakeAppId.required = true
+	cmdInstanceFake.Flags.Var(&instanceFlags.fakeGroupId, "group-id", "Group ID to update.")
+	instanceFlags.fakeGroupId.required = true
 	cmdInstanceFake.Flags.StringVar(&instanceFlags.version, "version", "0.0.0", "Version to report.")
 	cmdInstanceFake.Flags.BoolVar(&instanceFlags.forceUpdate, "force-update", false, "Force updates regardless of rate limiting")
 }
 
-func instanceListUpdates(args []string, service *update.Service, out *tabwriter.Writer) int {
+const instanceHeader = "AppID\tClientID\tVersion\tLastSeen\tGroup\tOEM\n"
+
+func instanceFields(cl *update.ClientUpdate) []string {
+	return []string{cl.AppId, cl.ClientId, cl.Version, cl.LastSeen, cl.GroupId, cl.Oem}
+}
+
+// instanceColumns are the columns instance list-updates can show.
+// instanceListFields selects the same columns as instanceHeader.
+var instanceColumns = []tableColumn{
+	{"app-id", "AppID", func(v interface{}) string { return v.(*update.ClientUpdate).AppId }},
+	{"client-id", "ClientID", func(v interface{}) string { return v.(*update.ClientUpdate).ClientId }},
+	{"version", "Version", func(v interface{}) string { return v.(*update.ClientUpdate).Version }},
+	{"last-seen", "LastSeen", func(v interface{}) string { return v.(*update.ClientUpdate).LastSeen }},
+	{"group", "Group", func(v interface{}) string { return v.(*update.ClientUpdate).GroupId }},
+	{"oem", "OEM", func(v interface{}) string { return v.(*update.ClientUpdate).Oem }},
+	{"status", "Status", func(v interface{}) string { return instanceStatus(v.(*update.ClientUpdate)) }},
+	{"event-type", "EventType", func(v interface{}) string { return v.(*update.ClientUpdate).EventType }},
+	{"event-result", "EventResult", func(v interface{}) string { return v.(*update.ClientUpdate).EventResult }},
+	{"error-code", "ErrorCode", func(v interface{}) string { return v.(*update.ClientUpdate).ErrorCode }},
+}
+
+const (
+	instanceListFields = "app-id,client-id,version,last-seen,group,oem"
+	instanceWideFields = instanceListFields + ",status,event-type,event-result,error-code"
+)
+
+// instanceFilterFields are the computed fields --filter accepts besides the
+// JSON keys of update.ClientUpdate.
+var instanceFilterFields = map[string]func(interface{}) string{
+	"status": func(v interface{}) string { return instanceStatus(v.(*update.ClientUpdate)) },
+}
+
+// staleAfter is how long an instance can go unseen before its last seen
+// time is highlighted.
+const staleAfter = 24 * time.Hour
+
+// relativeLastSeen returns columns with the last-seen column, if selected,
+// showing ages relative to now. Sorting is done beforehand on the times
+// themselves.
+func relativeLastSeen(columns []tableColumn, now time.Time) []tableColumn {
+	columns = append([]tableColumn(nil), columns...)
+	for i, c := range columns {
+		if c.name == "last-seen" {
+			columns[i].value = func(v interface{}) string {
+				lastSeen := v.(*update.ClientUpdate).LastSeen
+				t, err := time.Parse(time.RFC3339, lastSeen)
+				if err != nil {
+					return lastSeen
+				}
+				return formatAge(now.Sub(t))
+			}
+		}
+	}
+	return columns
+}
+
+// formatAge renders d in its largest whole unit, such as 3m ago or 2d ago.
+func formatAge(d time.Duration) string {
+	switch {
+	case d < 0:
+		return "just now"
+	case d < time.Minute:
+		return fmt.Sprintf("%ds ago", int(d/time.Second))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d/time.Hour))
+	}
+	return fmt.Sprintf("%dd ago", int(d/(24*time.Hour)))
+}
+
+// lastSeenStale reports whether a last-seen cell, a time or an age from
+// formatAge, is more than staleAfter in the past.
+func lastSeenStale(s string) bool {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return time.Since(t) > staleAfter
+	}
+	return strings.HasSuffix(s, "d ago")
+}
+
+// Instance statuses, summarizing the Omaha event an instance last reported.
+const (
+	instanceComplete = "complete"
+	instanceError    = "error"
+	instanceUpdating = "updating"
+)
+
+func instanceStatus(cl *update.ClientUpdate) string {
+	switch cl.EventResult {
+	case "0", "5", "6", "8", "10":
+		return instanceError
+	}
+	if cl.EventType == "3" {
+		return instanceComplete
+	}
+	return instanceUpdating
+}
+
+func instanceListUpdates(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	switch instanceFlags.format {
+	case "table", "csv":
+	case "wide":
+		if instanceFlags.fields != instanceListFields {
+			fmt.Fprintln(os.Stderr, "--fields can't be used with --format wide")
+			return ERROR_USAGE
+		}
+		instanceFlags.fields = instanceWideFields
+	default:
+		return ERROR_USAGE
+	}
+	if instanceFlags.relative && instanceFlags.absolute {
+		fmt.Fprintln(os.Stderr, "--relative and --absolute can't be used together")
+		return ERROR_USAGE
+	}
+	columns, err := selectColumns(instanceColumns, instanceFlags.fields)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ERROR_USAGE
+	}
+	var sortBy []tableColumn
+	if instanceFlags.sortBy != "" {
+		if sortBy, err = selectColumns(instanceColumns, instanceFlags.sortBy); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ERROR_USAGE
+		}
+	}
+
+	statuses := make(map[string]bool)
+	if instanceFlags.status != "" {
+		for _, s := range strings.Split(instanceFlags.status, ",") {
+			s = strings.TrimSpace(s)
+			switch s {
+			case instanceComplete, instanceError, instanceUpdating:
+				statuses[s] = true
+			default:
+				fmt.Fprintf(os.Stderr, "unknown status %q\n", s)
+				return ERROR_USAGE
+			}
+		}
+	}
+	filter, err := parseFilter(instanceFlags.filter, (*update.ClientUpdate)(nil), instanceFilterFields)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ERROR_USAGE
+	}
+
+	var items []*update.ClientUpdate
+	appIds := uniqueAppIds(instanceFlags.appIds)
+	if len(appIds) > 1 {
+		apps, err := listAppsByID(ctx, service)
+		if err != nil {
+			fatal(err)
+		}
+		label := make(map[string]string, len(apps))
+		for _, app := range apps {
+			label[app.Id] = app.Label
+		}
+		if items, err = listUpdatesOfApps(ctx, service, appIds, statuses, filter, sortBy); err != nil {
+			fatal(err)
+		}
+		columns = append([]tableColumn{
+			{"application", "Application", func(v interface{}) string { return label[v.(*update.ClientUpdate).AppId] }},
+		}, columns...)
+	} else {
+		if items, err = listUpdates(ctx, service, strings.Join(appIds, ","), statuses, filter); err != nil {
+			fatal(err)
+		}
+		sortByColumns(sortBy, items, instanceFlags.reverse)
+	}
+
+	if instanceFlags.count {
+		return printCount(out, items)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, items)
+	}
+
+	if globalFlags.Quiet {
+		return printIDs(out, items, "ClientId")
+	}
+
+	if instanceFlags.format == "csv" {
+		return writeInstancesCSV(stdout, columns, items)
+	}
+
+	relative := instanceFlags.relative
+	if !relative && !instanceFlags.absolute {
+		f, ok := stdout.(*os.File)
+		relative = ok && isTerminal(f)
+	}
+	if relative {
+		columns = relativeLastSeen(columns, time.Now())
+	}
+
+	printColumns(out, columns, items)
+	out.Flush()
+	return OK
+}
+
+// listUpdates fetches the page of instances selected by the list-updates
+// flags, of appId if it is not empty, keeping those with one of statuses, if
+// any, that filter matches.
+func listUpdates(ctx context.Context, service *update.Service, appId string, statuses map[string]bool, filter *listFilter) ([]*update.ClientUpdate, error) {
 	call := service.Clientupdate.List()
 	call.DateStart(instanceFlags.start)
 	call.DateEnd(instanceFlags.end)
+	if instanceFlags.limit > 0 {
+		call.Limit(instanceFlags.limit)
+	}
+	if instanceFlags.offset > 0 {
+		call.Skip(instanceFlags.offset)
+	}
 	if instanceFlags.groupId.Get() != nil {
 		call.GroupId(instanceFlags.groupId.String())
 	}
-	if instanceFlags.groupId.Get() != nil {
-		call.AppId(instanceFlags.appId.String())
+	if appId != "" {
+		call.AppId(appId)
+	}
+	if instanceFlags.listVersion != "" {
+		call.Version(instanceFlags.listVersion)
 	}
-	list, err := call.Do()
 
+	list, err := call.Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	fmt.Fprintln(out, "AppID\tClientID\tVersion\tLastSeen\tGroup\tOEM")
-	for _, cl := range list.Items {
-		fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%s\n", cl.AppId,
-			cl.ClientId, cl.Version, cl.LastSeen, cl.GroupId, cl.Oem)
+	// The API has no page tokens, so a full page is the only sign that
+	// there may be more to fetch.
+	if instanceFlags.limit > 0 && int64(len(list.Items)) == instanceFlags.limit {
+		of := ""
+		if len(uniqueAppIds(instanceFlags.appIds)) > 1 {
+			of = " of " + appId
+		}
+		fmt.Fprintf(os.Stderr, "more instances%s may be available, continue with --offset %d\n", of, instanceFlags.offset+instanceFlags.limit)
+	}
+
+	items := list.Items
+	if len(statuses) > 0 {
+		items = nil
+		for _, cl := range list.Items {
+			if statuses[instanceStatus(cl)] {
+				items = append(items, cl)
+			}
+		}
+	}
+	return filter.filter(items).([]*update.ClientUpdate), nil
+}
+
+// uniqueAppIds returns the app IDs given to --app-id lower cased, sorted and
+// without duplicates, so an app repeated in any case is listed once.
+func uniqueAppIds(ids []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, id := range ids {
+		if id = strings.ToLower(id); !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// listUpdatesOfApps runs listUpdates for each of appIds, as returned by
+// uniqueAppIds, concurrently, returning the instances in the order of appIds
+// and then sortBy within each application, so the order doesn't depend on
+// which finished first. --limit and --offset apply to each application.
+func listUpdatesOfApps(ctx context.Context, service *update.Service, appIds []string, statuses map[string]bool, filter *listFilter, sortBy []tableColumn) ([]*update.ClientUpdate, error) {
+	items := make([][]*update.ClientUpdate, len(appIds))
+	err := parallel(ctx, len(appIds), func(ctx context.Context, i int) error {
+		list, err := listUpdates(ctx, service, appIds[i], statuses, filter)
+		if err != nil {
+			return err
+		}
+		sortByColumns(sortBy, list, instanceFlags.reverse)
+		items[i] = list
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var all []*update.ClientUpdate
+	for _, list := range items {
+		all = append(all, list...)
+	}
+	return all, nil
+}
+
+func writeInstancesCSV(w io.Writer, columns []tableColumn, items []*update.ClientUpdate) int {
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = instanceFlags.crlf
+	if !globalFlags.NoHeaders {
+		cw.Write(columnHeaders(columns))
+	}
+	for _, cl := range items {
+		cw.Write(columnValues(columns, cl))
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Print(err)
+		return ERROR_API
+	}
+	return OK
+}
+
+// instanceSummaryPageSize is the number of instances fetched per request
+// while counting.
+const instanceSummaryPageSize = 500
+
+// countInstances pages through the instances of an application, or of one of
+// its groups if groupId is not empty, and counts them by key.
+func countInstances(ctx context.Context, service *update.Service, appId, groupId string, key func(*update.ClientUpdate) string) (map[string]int, int, error) {
+	counts := make(map[string]int)
+	total, err := forEachInstance(ctx, service, appId, groupId, func(cl *update.ClientUpdate) {
+		counts[key(cl)]++
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return counts, total, nil
+}
+
+// forEachInstance calls fn with every instance of an app, or of one of its
+// groups if groupId isn't empty, fetching them a page at a time. It returns
+// the number of instances.
+func forEachInstance(ctx context.Context, service *update.Service, appId, groupId string, fn func(*update.ClientUpdate)) (int, error) {
+	total := 0
+	for skip := int64(0); ; skip += instanceSummaryPageSize {
+		call := service.Clientupdate.List()
+		call.AppId(appId)
+		if groupId != "" {
+			call.GroupId(groupId)
+		}
+		call.Limit(instanceSummaryPageSize)
+		call.Skip(skip)
+		list, err := call.Context(ctx).Do()
+		if err != nil {
+			clearProgress()
+			return 0, err
+		}
+
+		for _, cl := range list.Items {
+			fn(cl)
+		}
+		total += len(list.Items)
+		if len(list.Items) < instanceSummaryPageSize {
+			break
+		}
+		showProgress("fetched %d instances...", total)
+	}
+	clearProgress()
+	return total, nil
+}
+
+type instanceCount struct {
+	Value   string  `json:"value"`
+	Count   int     `json:"count"`
+	Percent float64 `json:"percent"`
+}
+
+func instanceSummary(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if instanceFlags.appId.Get() == nil {
+		return ERROR_USAGE
+	}
+
+	key := func(cl *update.ClientUpdate) string { return cl.Version }
+	if instanceFlags.byStatus {
+		key = instanceStatus
+	}
+	counts, total, err := countInstances(ctx, service, instanceFlags.appId.String(), instanceFlags.groupId.String(), key)
+	if err != nil {
+		fatal(err)
+	}
+
+	summary := make([]instanceCount, 0, len(counts))
+	for value, count := range counts {
+		summary = append(summary, instanceCount{
+			Value:   value,
+			Count:   count,
+			Percent: 100 * float64(count) / float64(total),
+		})
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].Count != summary[j].Count {
+			return summary[i].Count > summary[j].Count
+		}
+		return summary[i].Value < summary[j].Value
+	})
+
+	if structuredOutput() {
+		return printStructured(out, summary)
+	}
+
+	column := "Version"
+	if instanceFlags.byStatus {
+		column = "Status"
+	}
+	printHeader(out, column+"\tCount\tPercent\n")
+	for _, c := range summary {
+		fmt.Fprintf(out, "%s\t%d\t%.1f%%\n", c.Value, c.Count, c.Percent)
 	}
 	out.Flush()
 	return OK
 }
 
-func instanceListAppVersions(args []string, service *update.Service, out *tabwriter.Writer) int {
+// instanceEvent is one entry of an instance's event log.
+type instanceEvent struct {
+	Time            string `json:"time"`
+	Type            string `json:"type"`
+	Result          string `json:"result"`
+	PreviousVersion string `json:"previousVersion,omitempty"`
+	Version         string `json:"version"`
+	GroupId         string `json:"groupId"`
+}
+
+// omahaName describes an Omaha event type or result code, falling back to
+// the code itself when it is unknown.
+func omahaName(names map[int]string, code string) string {
+	if n, err := strconv.Atoi(code); err == nil {
+		if name, ok := names[n]; ok {
+			return name
+		}
+	}
+	return code
+}
+
+func instanceFind(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if instanceFlags.appId.Get() == nil || instanceFlags.match == "" {
+		return ERROR_USAGE
+	}
+
+	pattern := regexp.QuoteMeta(instanceFlags.match)
+	if instanceFlags.regex {
+		pattern = instanceFlags.match
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --match: %v\n", err)
+		return ERROR_USAGE
+	}
+	re := regexp.MustCompile("(?i)" + pattern)
+
+	var found []*update.ClientUpdate
+	_, err := forEachInstance(ctx, service, instanceFlags.appId.String(), instanceFlags.groupId.String(), func(cl *update.ClientUpdate) {
+		if re.MatchString(cl.ClientId) {
+			found = append(found, cl)
+		}
+	})
+	if err != nil {
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, found)
+	}
+
+	if globalFlags.Quiet {
+		return printIDs(out, found, "ClientId")
+	}
+
+	columns, _ := selectColumns(instanceColumns, instanceListFields)
+	printColumns(out, columns, found)
+	out.Flush()
+	return OK
+}
+
+func instanceEvents(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if instanceFlags.instanceId == "" {
+		return ERROR_USAGE
+	}
+
+	list, err := service.Client.History(instanceFlags.instanceId).Context(ctx).Do()
+	if err != nil {
+		fatal(err)
+	}
+
+	items := list.Items
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].DateTime < items[j].DateTime
+	})
+
+	events := make([]instanceEvent, 0, len(items))
+	previous := ""
+	for _, item := range items {
+		if instanceFlags.groupId.Get() != nil && item.GroupId != instanceFlags.groupId.String() {
+			continue
+		}
+		events = append(events, instanceEvent{
+			Time:            time.Unix(item.DateTime, 0).UTC().Format(time.RFC3339),
+			Type:            omahaName(omaha.EventTypes, item.EventType),
+			Result:          omahaName(omaha.EventResults, item.EventResult),
+			PreviousVersion: previous,
+			Version:         item.Version,
+			GroupId:         item.GroupId,
+		})
+		previous = item.Version
+	}
+
+	if instanceFlags.eventsLimit > 0 && len(events) > instanceFlags.eventsLimit {
+		events = events[len(events)-instanceFlags.eventsLimit:]
+	}
+	if instanceFlags.reverse {
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+	}
+
+	if structuredOutput() {
+		return printStructured(out, events)
+	}
+
+	printHeader(out, "Time\tType\tResult\tPrevious Version\tVersion\tGroup\n")
+	for _, e := range events {
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Time, e.Type, e.Result, e.PreviousVersion, e.Version, e.GroupId)
+	}
+	out.Flush()
+	return OK
+}
+
+func instanceListAppVersions(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	call := service.Appversion.List()
 
 	if instanceFlags.groupId.Get() != nil {
@@ -136,13 +711,17 @@ func instanceListAppVersions(args []string, service *update.Service, out *tabwri
 		call.DateEnd(instanceFlags.end)
 	}
 
-	list, err := call.Do()
+	list, err := call.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, list.Items)
 	}
 
-	fmt.Fprintln(out, "AppID\tGroupID\tVersion\tClients")
+	printHeader(out, "AppID\tGroupID\tVersion\tClients\n")
 	for _, cl := range list.Items {
 		fmt.Fprintf(out, "%s\t%s\t%s\t%d\n", cl.AppId, cl.GroupId, cl.Version, cl.Count)
 	}
@@ -168,7 +747,7 @@ type Client struct {
 
 func (c *Client) Log(format string, v ...interface{}) {
 	format = c.Id + ": " + format
-	fmt.Printf(format, v...)
+	fmt.Fprintf(stdout, format, v...)
 }
 
 func (c *Client) OmahaRequest(otype, result string, updateCheck, isPing bool) *omaha.Request {
@@ -209,14 +788,13 @@ func (c *Client) OmahaRequest(otype, result string, updateCheck, isPing bool) *o
 }
 
 func (c *Client) MakeRequest(otype, result string, updateCheck, isPing bool) (*omaha.Response, error) {
-	client := &http.Client{}
 	req := c.OmahaRequest(otype, result, updateCheck, isPing)
 	raw, err := xml.MarshalIndent(req, "", " ")
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := client.Post(c.config.server+"/v1/update/", "text/xml", bytes.NewReader(raw))
+	resp, err := plainClient.Post(c.config.server+"/v1/update/", "text/xml", bytes.NewReader(raw))
 	if err != nil {
 		return nil, err
 	}
@@ -338,8 +916,8 @@ func randomHex(n int) string {
 	return string(b)
 }
 
-func instanceFake(args []string, service *update.Service, out *tabwriter.Writer) int {
-	if instanceFlags.appId.Get() == nil || instanceFlags.groupId.Get() == nil {
+func instanceFake(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if instanceFlags.fakeAppId.Get() == nil || instanceFlags.fakeGroupId.Get() == nil {
 		return ERROR_USAGE
 	}
 
@@ -357,8 +935,8 @@ func instanceFake(args []string, service *update.Service, out *tabwriter.Writer)
 			Id:             prefix + strings.Replace(uuid.New(), "-", "", -1)[14:],
 			SessionId:      uuid.New(),
 			Version:        instanceFlags.version,
-			AppId:          instanceFlags.appId.String(),
-			Track:          instanceFlags.groupId.String(),
+			AppId:          instanceFlags.fakeAppId.String(),
+			Track:          instanceFlags.fakeGroupId.String(),
 			config:         conf,
 			errorRate:      instanceFlags.errorRate,
 			pingsRemaining: instanceFlags.pingOnly,
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	update "github.com/coreos/updateservicectl/client/update/v1"
+)
+
+const (
+	testAppA = "11111111-2222-4333-8444-555555555555"
+	testAppB = "e96281a6-d1af-4bde-9a0a-97b76e56dc57"
+)
+
+func TestUniqueAppIds(t *testing.T) {
+	tests := []struct {
+		ids, want []string
+	}{
+		{nil, nil},
+		{[]string{testAppB}, []string{testAppB}},
+		{[]string{testAppB, testAppA}, []string{testAppA, testAppB}},
+		{[]string{testAppB, strings.ToUpper(testAppB)}, []string{testAppB}},
+		{[]string{testAppB, testAppA, testAppB, testAppA}, []string{testAppA, testAppB}},
+	}
+	for _, tt := range tests {
+		if got := uniqueAppIds(tt.ids); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("uniqueAppIds(%v) = %v, want %v", tt.ids, got, tt.want)
+		}
+	}
+}
+
+func TestListUpdatesOfApps(t *testing.T) {
+	instances := map[string][]*update.ClientUpdate{
+		testAppA: {
+			{AppId: testAppA, ClientId: "c"},
+			{AppId: testAppA, ClientId: "a"},
+		},
+		testAppB: {
+			{AppId: testAppB, ClientId: "b"},
+			{AppId: testAppB, ClientId: "d"},
+			{AppId: testAppB, ClientId: "a"},
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appId := r.URL.Query().Get("appId")
+		if appId == testAppA {
+			// answer the first app last
+			time.Sleep(20 * time.Millisecond)
+		}
+		json.NewEncoder(w).Encode(&update.ClientUpdateList{Items: instances[appId]})
+	}))
+	defer server.Close()
+
+	service, err := update.New(server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.BasePath = server.URL + "/"
+
+	saved := globalFlags.Concurrency
+	globalFlags.Concurrency = 2
+	defer func() { globalFlags.Concurrency = saved }()
+
+	filter, err := parseFilter("", (*update.ClientUpdate)(nil), instanceFilterFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortBy, err := selectColumns(instanceColumns, "client-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appIds := uniqueAppIds([]string{testAppB, testAppA, strings.ToUpper(testAppB)})
+	items, err := listUpdatesOfApps(context.Background(), service, appIds, nil, filter, sortBy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, cl := range items {
+		got = append(got, cl.AppId[:8]+"/"+cl.ClientId)
+	}
+	want := []string{"11111111/a", "11111111/c", "e96281a6/a", "e96281a6/b", "e96281a6/d"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("listUpdatesOfApps returned %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"unicode"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+type outputFormat string
+
+const (
+	outputTable outputFormat = "table"
+	outputJSON  outputFormat = "json"
+	outputYAML  outputFormat = "yaml"
+
+	// outputTemplate is selected with --output template=<text>, where text is
+	// a text/template executed against each result.
+	outputTemplate outputFormat = "template"
+
+	// outputPrometheus writes Prometheus text format metrics. Only watch
+	// supports it.
+	outputPrometheus outputFormat = "prometheus"
+
+	// outputEnv writes the scalar fields of a single resource as shell
+	// variable assignments, for eval.
+	outputEnv outputFormat = "env"
+)
+
+var (
+	// output is the format selected with --output. It is set by handle()
+	// before a command runs.
+	output = outputTable
+
+	// outputTmpl holds the parsed template when output is outputTemplate.
+	outputTmpl *template.Template
+)
+
+func parseOutputFormat(value string) (outputFormat, error) {
+	if strings.HasPrefix(value, string(outputTemplate)+"=") {
+		tmpl, err := template.New("output").Parse(strings.SplitN(value, "=", 2)[1])
+		if err != nil {
+			return "", err
+		}
+		outputTmpl = tmpl
+		return outputTemplate, nil
+	}
+
+	switch f := outputFormat(value); f {
+	case outputTable, outputJSON, outputYAML, outputPrometheus, outputEnv:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown output format %q", value)
+}
+
+// structuredOutput reports whether results should be serialized instead of
+// written as tabwriter columns.
+func structuredOutput() bool {
+	return output != outputTable
+}
+
+// printHeader writes a table header line unless --no-headers was given.
+func printHeader(out io.Writer, header string) {
+	if !globalFlags.NoHeaders {
+		fmt.Fprint(out, header)
+	}
+}
+
+// printIDs implements --quiet by writing the named identifier field of each
+// element of items, one per line.
+func printIDs(out *tabwriter.Writer, items interface{}, field string) int {
+	rv := reflect.ValueOf(items)
+	for i := 0; i < rv.Len(); i++ {
+		fmt.Fprintln(out, reflect.Indirect(rv.Index(i)).FieldByName(field).String())
+	}
+	out.Flush()
+	return OK
+}
+
+// printCount implements --count by writing the number of elements of items.
+func printCount(out *tabwriter.Writer, items interface{}) int {
+	fmt.Fprintln(out, reflect.ValueOf(items).Len())
+	out.Flush()
+	return OK
+}
+
+// printStructured serializes docs in the selected output format. Nil slices
+// are written as empty lists so the output is always well formed. When more
+// than one resource is given, JSON output wraps them in an array and YAML
+// output writes one document per resource. Templates are executed once per
+// resource and written directly to stdout, bypassing column alignment.
+func printStructured(out *tabwriter.Writer, docs ...interface{}) int {
+	for i, v := range docs {
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.IsNil() {
+			docs[i] = []interface{}{}
+		}
+	}
+
+	switch output {
+	case outputJSON:
+		var v interface{} = docs
+		if len(docs) == 1 {
+			v = docs[0]
+		}
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			log.Print(err)
+			return ERROR_API
+		}
+		fmt.Fprintf(out, "%s\n", b)
+	case outputYAML:
+		for i, v := range docs {
+			b, err := marshalYAML(v)
+			if err != nil {
+				log.Print(err)
+				return ERROR_API
+			}
+			if i > 0 {
+				fmt.Fprintln(out, "---")
+			}
+			fmt.Fprintf(out, "%s", b)
+		}
+	case outputTemplate:
+		out.Flush()
+		for _, v := range docs {
+			if err := outputTmpl.Execute(stdout, v); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return ERROR_USAGE
+			}
+		}
+	case outputPrometheus:
+		fmt.Fprintln(os.Stderr, "--output prometheus is only supported by watch")
+		return ERROR_USAGE
+	case outputEnv:
+		out.Flush()
+		return printEnv(docs)
+	}
+
+	out.Flush()
+	return OK
+}
+
+// printEnv writes the scalar fields of the resource in docs as
+// UPDATECTL_<FIELD>='value' lines to stdout, bypassing column alignment so
+// values keep their tabs. Fields holding lists or objects are skipped, and
+// named on stderr.
+func printEnv(docs []interface{}) int {
+	var rv reflect.Value
+	if len(docs) == 1 {
+		rv = reflect.Indirect(reflect.ValueOf(docs[0]))
+	}
+	if rv.Kind() != reflect.Struct {
+		fmt.Fprintln(os.Stderr, "--output env is only supported by commands showing a single resource")
+		return ERROR_USAGE
+	}
+
+	var skipped []string
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+			continue
+		}
+		v := reflect.Indirect(rv.Field(i))
+		var value string
+		switch v.Kind() {
+		case reflect.Invalid:
+			// a nil pointer
+		case reflect.String:
+			value = v.String()
+		case reflect.Bool:
+			value = strconv.FormatBool(v.Bool())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			value = strconv.FormatInt(v.Int(), 10)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			value = strconv.FormatUint(v.Uint(), 10)
+		case reflect.Float32, reflect.Float64:
+			value = strconv.FormatFloat(v.Float(), 'g', -1, 64)
+		default:
+			skipped = append(skipped, field.Name)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s%s=%s\n", envPrefix, envFieldName(field.Name), shellQuote(value))
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintf(os.Stderr, "--output env skipped fields that aren't scalars: %s\n", strings.Join(skipped, ", "))
+	}
+	return OK
+}
+
+// envFieldName upper cases a Go field name, separating its words with
+// underscores: AppId becomes APP_ID.
+func envFieldName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := rune(name[i-1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// shellQuote single quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// columnColors maps the names of columns whose values printColumns colors to
+// the function coloring them. CSV output and sorting see the plain values.
+var columnColors = map[string]func(string) string{
+	"status":    colorStatus,
+	"last-seen": colorLastSeen,
+}
+
+// columnAliases maps column names to the headers printColumns shows for them
+// instead of their own, as set in the [columns] table of the config file.
+var columnAliases map[string]string
+
+// tableColumn is a column list commands can select with --fields.
+type tableColumn struct {
+	name   string // name given to --fields
+	header string
+	value  func(item interface{}) string
+}
+
+// selectColumns returns the columns named by fields, a comma separated list,
+// in the order given.
+func selectColumns(columns []tableColumn, fields string) ([]tableColumn, error) {
+	byName := make(map[string]tableColumn, len(columns))
+	for _, c := range columns {
+		byName[c.name] = c
+	}
+
+	var selected []tableColumn
+	for _, name := range strings.Split(fields, ",") {
+		c, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q, valid fields are: %s", strings.TrimSpace(name), columnNames(columns))
+		}
+		selected = append(selected, c)
+	}
+	return selected, nil
+}
+
+// printColumns writes a header line for columns followed by one row per
+// element of items.
+func printColumns(out *tabwriter.Writer, columns []tableColumn, items interface{}) {
+	rv := reflect.ValueOf(items)
+	rows := make([][]string, rv.Len())
+	for i := range rows {
+		rows[i] = columnValues(columns, rv.Index(i).Interface())
+	}
+
+	// tabwriter counts color sequences as text, so the headers of colored
+	// columns get a sequence of the same length to stay aligned.
+	headers := columnHeaders(columns)
+	for i, c := range columns {
+		if alias, ok := columnAliases[c.name]; ok {
+			headers[i] = alias
+		}
+		if color, ok := columnColors[c.name]; ok && colorOutput {
+			headers[i] = colorize(ansiDefault, headers[i])
+			for _, row := range rows {
+				row[i] = color(row[i])
+			}
+		}
+	}
+
+	printHeader(out, strings.Join(headers, "\t")+"\n")
+	for _, row := range rows {
+		fmt.Fprintln(out, strings.Join(row, "\t"))
+	}
+}
+
+// columnNames lists the names of columns for flag usage messages.
+func columnNames(columns []tableColumn) string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// sortByColumns stably sorts items, a slice, by the given columns, so rows
+// with equal values keep the order the server returned them in. A column
+// whose values are all versions or all numbers is compared as such.
+func sortByColumns(columns []tableColumn, items interface{}, reverse bool) {
+	rv := reflect.ValueOf(items)
+	less := make([]func(a, b string) bool, len(columns))
+	for k, c := range columns {
+		values := make([]string, rv.Len())
+		for i := range values {
+			values[i] = c.value(rv.Index(i).Interface())
+		}
+		less[k] = columnLess(values)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if reverse {
+			i, j = j, i
+		}
+		for k, c := range columns {
+			a, b := c.value(rv.Index(i).Interface()), c.value(rv.Index(j).Interface())
+			if less[k](a, b) {
+				return true
+			}
+			if less[k](b, a) {
+				return false
+			}
+		}
+		return false
+	})
+}
+
+// columnLess returns the comparison used to sort values.
+func columnLess(values []string) func(a, b string) bool {
+	versions, numbers := true, true
+	for _, v := range values {
+		if _, err := semver.NewVersion(v); err != nil {
+			versions = false
+		}
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			numbers = false
+		}
+	}
+
+	switch {
+	case versions:
+		return func(a, b string) bool {
+			x, _ := semver.NewVersion(a)
+			y, _ := semver.NewVersion(b)
+			return x.LessThan(*y)
+		}
+	case numbers:
+		return func(a, b string) bool {
+			x, _ := strconv.ParseFloat(a, 64)
+			y, _ := strconv.ParseFloat(b, 64)
+			return x < y
+		}
+	}
+	return func(a, b string) bool { return a < b }
+}
+
+func columnHeaders(columns []tableColumn) []string {
+	headers := make([]string, len(columns))
+	for i, c := range columns {
+		headers[i] = c.header
+	}
+	return headers
+}
+
+func columnValues(columns []tableColumn, item interface{}) []string {
+	values := make([]string, len(columns))
+	for i, c := range columns {
+		values[i] = c.value(item)
+	}
+	return values
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+
+	update "github.com/coreos/updateservicectl/client/update/v1"
+)
+
+func TestEnvFieldName(t *testing.T) {
+	tests := []struct {
+		field, want string
+	}{
+		{"Id", "ID"},
+		{"AppId", "APP_ID"},
+		{"OemBlacklist", "OEM_BLACKLIST"},
+		{"UpdatePercent", "UPDATE_PERCENT"},
+		{"Sha256Sum", "SHA256_SUM"},
+		{"URL", "URL"},
+	}
+	for _, tt := range tests {
+		if got := envFieldName(tt.field); got != tt.want {
+			t.Errorf("envFieldName(%q) = %q, want %q", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	values := []string{
+		"",
+		"plain",
+		"it's",
+		"''",
+		"two\nlines",
+		"$(touch /tmp/pwned) `id` $HOME",
+		`back\slash "double"`,
+	}
+	for _, v := range values {
+		q := shellQuote(v)
+		if !strings.HasPrefix(q, "'") || !strings.HasSuffix(q, "'") {
+			t.Errorf("shellQuote(%q) = %q, want it single quoted", v, q)
+		}
+
+		// The shell must read the quoted value back unchanged.
+		sh, err := exec.LookPath("sh")
+		if err != nil {
+			continue
+		}
+		got, err := exec.Command(sh, "-c", "printf %s "+q).Output()
+		if err != nil {
+			t.Errorf("sh rejected shellQuote(%q) = %q: %v", v, q, err)
+			continue
+		}
+		if string(got) != v {
+			t.Errorf("sh read shellQuote(%q) back as %q", v, got)
+		}
+	}
+}
+
+func TestPrintEnv(t *testing.T) {
+	var buf bytes.Buffer
+	saved := stdout
+	stdout = &buf
+	defer func() { stdout = saved }()
+
+	group := &update.Group{
+		AppId:         "e96281a6-d1af-4bde-9a0a-97b76e56dc57",
+		Id:            "beta",
+		Label:         "it's $(beta)",
+		UpdatePercent: 50,
+		UpdatesPaused: true,
+	}
+	if code := printEnv([]interface{}{group}); code != OK {
+		t.Fatalf("printEnv returned %d", code)
+	}
+
+	for _, want := range []string{
+		"UPDATECTL_APP_ID='e96281a6-d1af-4bde-9a0a-97b76e56dc57'\n",
+		"UPDATECTL_ID='beta'\n",
+		`UPDATECTL_LABEL='it'\''s $(beta)'` + "\n",
+		"UPDATECTL_UPDATE_PERCENT='50'\n",
+		"UPDATECTL_UPDATES_PAUSED='true'\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, buf.String())
+		}
+	}
+
+	if code := printEnv([]interface{}{group, group}); code != ERROR_USAGE {
+		t.Errorf("printEnv of two resources returned %d, want %d", code, ERROR_USAGE)
+	}
+}
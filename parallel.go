@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+// parallel calls fn for each index in [0, n) on up to --concurrency
+// goroutines. Callers collect results by index, so their output order does
+// not depend on which call finishes first. The first error cancels the
+// context passed to the calls still running and is returned once they have
+// all stopped.
+func parallel(ctx context.Context, n int, fn func(ctx context.Context, i int) error) error {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := globalFlags.Concurrency
+	if workers > n {
+		workers = n
+	}
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	jobs := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := fn(ctx, i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr == nil {
+		return parent.Err()
+	}
+	return firstErr
+}
+
+// listAppsByID returns every application sorted by ID, the order multi-app
+// commands print their results in.
+func listAppsByID(ctx context.Context, service *update.Service) ([]*update.App, error) {
+	apps, err := service.App.List().Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(apps.Items, func(i, j int) bool {
+		return apps.Items[i].Id < apps.Items[j].Id
+	})
+	return apps.Items, nil
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
-	"bytes"
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -24,6 +24,7 @@ import (
 
 	"github.com/cheggaaa/pb"
 	"github.com/coreos/go-semver/semver"
+	"golang.org/x/net/context"
 
 	update "github.com/coreos/updateservicectl/client/update/v1"
 )
@@ -38,16 +39,22 @@ var (
 	createBulkGroup sync.WaitGroup
 
 	packageFlags struct {
-		appId        StringFlag
+		appId        UUIDFlag
 		minVersion   StringFlag
 		version      StringFlag
 		url          string
 		file         string
+		size         string
+		sha1         string
+		sha256       string
 		meta         string
 		releaseNotes string
 		saveDir      string
 		bulkDir      string
 		baseUrl      string
+		yes          bool
+		human        bool
+		bytes        bool
 	}
 
 	cmdPackage = &Command{
@@ -80,7 +87,7 @@ var (
 	cmdPackageDelete = &Command{
 		Name:        "package delete",
 		Usage:       "[OPTION]...",
-		Description: `Delete a package for an application.`,
+		Description: `Delete a package for an application. Asks for confirmation unless --yes is given.`,
 		Run:         packageDelete,
 	}
 	cmdPackageDownload = &Command{
@@ -115,6 +122,12 @@ var (
 func init() {
 	cmdPackageList.Flags.Var(&packageFlags.appId, "app-id",
 		"Application to list the package of.")
+	cmdPackageList.Flags.Var(&packageFlags.version, "version",
+		"Only list the package with this version.")
+	cmdPackageList.Flags.BoolVar(&packageFlags.human, "human", false,
+		"Show sizes in KiB, MiB or GiB. The default on a terminal.")
+	cmdPackageList.Flags.BoolVar(&packageFlags.bytes, "bytes", false,
+		"Show sizes in bytes.")
 
 	cmdPackageCreate.Flags.Var(&packageFlags.appId, "app-id",
 		"Application to add the package to.")
@@ -129,7 +142,16 @@ func init() {
 		"File contianing release notes for package.")
 	cmdPackageCreate.Flags.StringVar(&packageFlags.file,
 		"file", "",
-		"Path to package file (does not upload file).")
+		"Path to package file (does not upload file). Used to compute any of --size, --sha1 and --sha256 not given.")
+	cmdPackageCreate.Flags.StringVar(&packageFlags.size,
+		"size", "",
+		"Size of the package payload in bytes.")
+	cmdPackageCreate.Flags.StringVar(&packageFlags.sha1,
+		"sha1", "",
+		"Base64 encoded SHA-1 of the package payload.")
+	cmdPackageCreate.Flags.StringVar(&packageFlags.sha256,
+		"sha256", "",
+		"SHA-256 of the package payload, hex or base64 encoded.")
 
 	cmdPackageCreateBulk.Flags.StringVar(&packageFlags.bulkDir,
 		"dir", "",
@@ -142,6 +164,8 @@ func init() {
 		"Application with package to delete.")
 	cmdPackageDelete.Flags.Var(&packageFlags.version, "version",
 		"Version of package to delete.")
+	cmdPackageDelete.Flags.BoolVar(&packageFlags.yes, "yes", false,
+		"Delete without asking for confirmation.")
 
 	cmdPackageDownload.Flags.Var(&packageFlags.appId, "app-id",
 		"Application to download packages of. (optional)")
@@ -159,94 +183,165 @@ func init() {
 	cmdPackageUploadPayloadBulk.Flags.StringVar(&packageFlags.bulkDir,
 		"dir", "",
 		"Directory containing files to upload.")
+
+	addAppLabelFlag(&packageFlags.appId, cmdPackageList, cmdPackageCreate, cmdPackageDelete, cmdPackageDownload)
 }
 
-const packageHeader = "Version\tURL\tSize\n"
+const packageHeader = "Version\tSize\tSHA256\tSHA1\tURL\n"
 
 func formatPackage(pkg *update.Package) string {
-	return fmt.Sprintf("%s\t%s\t%s\n", pkg.Version, pkg.Url, pkg.Size)
+	return formatPackageSize(pkg, pkg.Size)
 }
 
-func packageCreate(args []string, service *update.Service, out *tabwriter.Writer) int {
-	if packageFlags.appId.Get() == nil ||
-		packageFlags.version.Get() == nil {
-		return ERROR_USAGE
-	}
+func formatPackageSize(pkg *update.Package, size string) string {
+	return fmt.Sprintf("%s\t%s\t%s\t%s\t%s\n", pkg.Version, size, pkg.Sha256Sum, pkg.Sha1Sum, pkg.Url)
+}
 
-	file := packageFlags.file
-	info, err := os.Stat(file)
+// formatBytes renders a size in bytes in the largest binary unit it reaches,
+// such as 1.5 MiB. Sizes that aren't numbers are returned as they are.
+func formatBytes(size string) string {
+	n, err := strconv.ParseInt(size, 10, 64)
 	if err != nil {
-		log.Fatalf("stat of %s failed: %v", file, err)
+		return size
 	}
-	content, err := ioutil.ReadFile(file)
-	if err != nil {
-		log.Fatalf("reading %s failed: %v", file, err)
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v := float64(n)
+	for _, unit := range []string{"KiB", "MiB", "GiB", "TiB"} {
+		v /= 1024
+		if v < 1024 || unit == "TiB" {
+			return fmt.Sprintf("%.1f %s", v, unit)
+		}
+	}
+	return size
+}
+
+func packageCreate(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if packageFlags.appId.Get() == nil ||
+		packageFlags.version.Get() == nil {
+		return ERROR_USAGE
 	}
 
 	metaFile := packageFlags.meta
 	var meta MetadataFile
 	if metaFile != "" {
-		content, err = ioutil.ReadFile(metaFile)
-		if err != nil {
-			log.Fatalf("reading %s failed: %v", metaFile, err)
+		content, err := ioutil.ReadFile(metaFile)
+		if err == nil {
+			err = json.Unmarshal(content, &meta)
 		}
-		err = json.Unmarshal(content, &meta)
 		if err != nil {
-			log.Fatalf("reading %s failed: %v", metaFile, err)
+			log.Printf("reading %s failed: %v", metaFile, err)
+			return ERROR_USAGE
 		}
 	}
 
 	releaseNotesFile := packageFlags.releaseNotes
 	var notes = make([]byte, 0)
 	if releaseNotesFile != "" {
-		notes, err = ioutil.ReadFile(releaseNotesFile)
+		content, err := ioutil.ReadFile(releaseNotesFile)
 		if err != nil {
-			log.Fatalf("reading %s failed: %v", releaseNotesFile, err)
+			log.Printf("reading %s failed: %v", releaseNotesFile, err)
+			return ERROR_USAGE
 		}
+		notes = content
 	}
 
-	var sha1base64 bytes.Buffer
-	encoder := base64.NewEncoder(base64.StdEncoding, &sha1base64)
-	sha1h := sha1.New()
-	io.Copy(sha1h, bytes.NewReader(content))
-	encoder.Write(sha1h.Sum(nil))
-	encoder.Close()
-
-	var sha256base64 bytes.Buffer
-	encoder = base64.NewEncoder(base64.StdEncoding, &sha256base64)
-	sha256h := sha256.New()
-	io.Copy(sha256h, bytes.NewReader(content))
-	encoder.Write(sha256h.Sum(nil))
-	encoder.Close()
+	sums := packageSums{
+		size:   packageFlags.size,
+		sha1:   packageFlags.sha1,
+		sha256: packageFlags.sha256,
+	}
+	if err := sums.fill(packageFlags.file); err != nil {
+		fmt.Fprintln(os.Stderr, redact(errorMessage(err)))
+		return ERROR_USAGE
+	}
 
 	pkg := &update.Package{
 		Url:                  packageFlags.url,
-		Size:                 strconv.FormatInt(info.Size(), 10),
-		Sha1Sum:              sha1base64.String(),
-		Sha256Sum:            sha256base64.String(),
+		Size:                 sums.size,
+		Sha1Sum:              sums.sha1,
+		Sha256Sum:            sums.sha256,
 		MetadataSignatureRsa: meta.MetadataSignatureRsa,
 		MetadataSize:         meta.MetadataSize,
 		ReleaseNotes:         string(notes),
 	}
 
-	jbytes, _ := json.MarshalIndent(pkg, "", " ")
-	fmt.Printf("%s\n", string(jbytes))
+	if !structuredOutput() && !globalFlags.DryRun {
+		jbytes, _ := json.MarshalIndent(pkg, "", " ")
+		fmt.Fprintf(out, "%s\n", string(jbytes))
+		out.Flush()
+	}
 
+	if dryRun("POST", apiURL(service.BasePath, "apps", packageFlags.appId.String(), "packages", packageFlags.version.String()), pkg) {
+		return OK
+	}
 	call := service.App.Package.Insert(packageFlags.appId.String(), packageFlags.version.String(), pkg)
-	pkg, err = call.Do()
+	pkg, err := call.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, pkg)
 	}
 
-	fmt.Fprint(out, packageHeader)
+	printHeader(out, packageHeader)
 	fmt.Fprintf(out, "%s", formatPackage(pkg))
 
 	out.Flush()
 	return OK
 }
 
-func uploadPayload(service *update.Service, file string) error {
+// packageSums holds the size and base64 encoded digests of a package
+// payload, as the roller stores them.
+type packageSums struct {
+	size   string
+	sha1   string
+	sha256 string
+}
+
+// fill computes whichever of the sums are unset by reading file. A hex
+// encoded sha256, as printed by sha256sum, is converted to base64.
+func (s *packageSums) fill(file string) error {
+	if raw, err := hex.DecodeString(s.sha256); err == nil && len(raw) == sha256.Size {
+		s.sha256 = base64.StdEncoding.EncodeToString(raw)
+	}
+
+	if s.size != "" && s.sha1 != "" && s.sha256 != "" {
+		return nil
+	}
+	if file == "" {
+		return errors.New("either --file or all of --size, --sha1 and --sha256 are required")
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sha1h := sha1.New()
+	sha256h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(sha1h, sha256h), f)
+	if err != nil {
+		return fmt.Errorf("reading %s failed: %v", file, err)
+	}
+
+	if s.size == "" {
+		s.size = strconv.FormatInt(size, 10)
+	}
+	if s.sha1 == "" {
+		s.sha1 = base64.StdEncoding.EncodeToString(sha1h.Sum(nil))
+	}
+	if s.sha256 == "" {
+		s.sha256 = base64.StdEncoding.EncodeToString(sha256h.Sum(nil))
+	}
+	return nil
+}
+
+func uploadPayload(ctx context.Context, service *update.Service, file string) error {
 	if file == "" {
 		return errors.New("missing file argument")
 	}
@@ -256,6 +351,10 @@ func uploadPayload(service *update.Service, file string) error {
 		return err
 	}
 
+	if dryRun("POST", globalFlags.Server+"/package-upload", map[string]string{"file": fpath}) {
+		return nil
+	}
+
 	f, err := os.Open(fpath)
 	if err != nil {
 		return err
@@ -280,12 +379,11 @@ func uploadPayload(service *update.Service, file string) error {
 	if err != nil {
 		return err
 	}
+	req = req.WithContext(ctx)
 
 	req.Header.Add("Content-Type", writer.FormDataContentType())
 
-	client := getHawkClient(globalFlags.User, globalFlags.Key)
-
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -307,19 +405,21 @@ func uploadPayload(service *update.Service, file string) error {
 	return <-errChan
 }
 
-func packageUploadPayload(args []string, service *update.Service, out *tabwriter.Writer) int {
-	err := uploadPayload(service, packageFlags.file)
+func packageUploadPayload(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	err := uploadPayload(ctx, service, packageFlags.file)
 	if err != nil {
-		fmt.Println("error uploading file")
-		fmt.Print(err)
+		fmt.Fprintln(os.Stderr, "error uploading file:", err)
 		return ERROR_USAGE
 	}
 
-	fmt.Printf("uploaded file %s\n", packageFlags.file)
+	if !globalFlags.DryRun {
+		fmt.Fprintf(out, "uploaded file %s\n", packageFlags.file)
+		out.Flush()
+	}
 	return OK
 }
 
-func packageUploadPayloadBulk(args []string, service *update.Service, out *tabwriter.Writer) int {
+func packageUploadPayloadBulk(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	bulkDir := packageFlags.bulkDir
 	if bulkDir == "" {
 		return ERROR_USAGE
@@ -341,12 +441,14 @@ func packageUploadPayloadBulk(args []string, service *update.Service, out *tabwr
 	for _, file := range files {
 		if file.Mode().IsRegular() {
 			total++
-			err := uploadPayload(service, path.Join(absDir, file.Name()))
+			err := uploadPayload(ctx, service, path.Join(absDir, file.Name()))
 			if err != nil {
 				errorCount++
-				fmt.Print(err)
+				fmt.Fprintln(os.Stderr, redact(errorMessage(err)))
+			} else if !globalFlags.DryRun {
+				fmt.Fprintf(out, "uploaded %s\n", file.Name())
+				out.Flush()
 			}
-			fmt.Printf("uploaded %s\n", file.Name())
 		}
 	}
 
@@ -354,7 +456,7 @@ func packageUploadPayloadBulk(args []string, service *update.Service, out *tabwr
 	return OK
 }
 
-func packageCreateBulk(args []string, service *update.Service, out *tabwriter.Writer) int {
+func packageCreateBulk(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	bulkDir := packageFlags.bulkDir
 	if bulkDir == "" {
 		cwd, err := os.Getwd()
@@ -386,6 +488,7 @@ func packageCreateBulk(args []string, service *update.Service, out *tabwriter.Wr
 			total++
 			createBulkGroup.Add(1)
 			go createPackageFromInfoFile(
+				ctx,
 				path.Join(bulkDir, file.Name()),
 				service,
 				errorHandler,
@@ -400,7 +503,7 @@ func packageCreateBulk(args []string, service *update.Service, out *tabwriter.Wr
 	return OK
 }
 
-func createPackageFromInfoFile(filename string, service *update.Service, handleError func(error)) {
+func createPackageFromInfoFile(ctx context.Context, filename string, service *update.Service, handleError func(error)) {
 	// Load metadata from package info.json into struct
 	pkg := new(update.Package)
 	jsonBody, err := ioutil.ReadFile(filename)
@@ -436,8 +539,12 @@ func createPackageFromInfoFile(filename string, service *update.Service, handleE
 	}
 
 	// Add package
+	if dryRun("POST", apiURL(service.BasePath, "apps", pkg.AppId, "packages", pkg.Version), pkg) {
+		createBulkGroup.Done()
+		return
+	}
 	call := service.App.Package.Insert(pkg.AppId, pkg.Version, pkg)
-	pkg, err = call.Do()
+	pkg, err = call.Context(ctx).Do()
 
 	if err != nil {
 		handleError(err)
@@ -447,41 +554,78 @@ func createPackageFromInfoFile(filename string, service *update.Service, handleE
 	return
 }
 
-func packageList(args []string, service *update.Service, out *tabwriter.Writer) int {
+func packageList(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if packageFlags.appId.Get() == nil {
 		return ERROR_USAGE
 	}
 
 	call := service.App.Package.List(packageFlags.appId.String())
-	list, err := call.Do()
+	if packageFlags.version.Get() != nil {
+		call.Version(packageFlags.version.String())
+	}
+	if packageFlags.human && packageFlags.bytes {
+		fmt.Fprintln(os.Stderr, "--human and --bytes can't be used together")
+		return ERROR_USAGE
+	}
+	list, err := call.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, list.Items)
+	}
+
+	if globalFlags.Quiet {
+		return printIDs(out, list.Items, "Version")
+	}
+
+	human := packageFlags.human
+	if !human && !packageFlags.bytes {
+		f, ok := stdout.(*os.File)
+		human = ok && isTerminal(f)
 	}
 
-	fmt.Fprint(out, packageHeader)
+	printHeader(out, packageHeader)
 	for _, pkg := range list.Items {
-		fmt.Fprintf(out, "%s", formatPackage(pkg))
+		size := pkg.Size
+		if human {
+			size = formatBytes(size)
+		}
+		fmt.Fprintf(out, "%s", formatPackageSize(pkg, size))
 	}
 
 	out.Flush()
 	return OK
 }
 
-func packageDelete(args []string, service *update.Service, out *tabwriter.Writer) int {
+func packageDelete(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if packageFlags.appId.Get() == nil ||
 		packageFlags.version.Get() == nil {
 		return ERROR_USAGE
 	}
 
+	if dryRun("DELETE", apiURL(service.BasePath, "apps", packageFlags.appId.String(), "packages", packageFlags.version.String()), nil) {
+		return OK
+	}
+	if !confirmDestructiveName(ctx, packageFlags.yes, fmt.Sprintf("Delete package %s of app %s?", packageFlags.version.String(), packageFlags.appId.String()), packageFlags.version.String()) {
+		return ERROR_ABORTED
+	}
+
 	call := service.App.Package.Delete(packageFlags.appId.String(), packageFlags.version.String())
-	pkg, err := call.Do()
+	pkg, err := call.Context(ctx).Do()
 
 	if err != nil {
-		log.Fatal(err)
+		// e.g. a conflict while a channel still points at the package
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, pkg)
 	}
 
-	fmt.Fprint(out, packageHeader)
+	printHeader(out, packageHeader)
 	fmt.Fprintf(out, "%s", formatPackage(pkg))
 
 	out.Flush()
@@ -489,7 +633,7 @@ func packageDelete(args []string, service *update.Service, out *tabwriter.Writer
 
 }
 
-func packageDownload(args []string, service *update.Service, out *tabwriter.Writer) int {
+func packageDownload(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	saveDir, err := getPackageSaveDirectory()
 	if err != nil {
 		log.Print(err)
@@ -515,7 +659,7 @@ func packageDownload(args []string, service *update.Service, out *tabwriter.Writ
 	}
 
 	call := service.App.Package.PublicList()
-	pkgs, err := call.Do()
+	pkgs, err := call.Context(ctx).Do()
 	if err != nil {
 		log.Print(err)
 		return ERROR_USAGE
@@ -3,16 +3,17 @@ package main
 import (
 	"fmt"
 	"io"
-	"log"
 	"math"
 	"text/tabwriter"
 
+	"golang.org/x/net/context"
+
 	"github.com/coreos/updateservicectl/client/update/v1"
 )
 
 var (
 	rolloutFlags struct {
-		appId   StringFlag
+		appId   UUIDFlag
 		groupId StringFlag
 		active  bool
 
@@ -63,6 +64,9 @@ var (
 )
 
 func init() {
+	rolloutFlags.appId.required = true
+	rolloutFlags.groupId.required = true
+
 	// flags for getting a rollout
 	cmdRollout.Flags.Var(&rolloutFlags.appId, "app-id",
 		"Application containing the group the rollout is associated with.")
@@ -100,7 +104,7 @@ func displayRollout(out io.Writer, rollout *update.Rollout) {
 	}
 }
 
-func rolloutGet(args []string, service *update.Service, out *tabwriter.Writer) int {
+func rolloutGet(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if rolloutFlags.appId.Get() == nil ||
 		rolloutFlags.groupId.Get() == nil {
 		return ERROR_USAGE
@@ -109,9 +113,13 @@ func rolloutGet(args []string, service *update.Service, out *tabwriter.Writer) i
 	call := service.Group.Rollout.Get(
 		rolloutFlags.appId.String(), rolloutFlags.groupId.String())
 
-	rollout, err := call.Do()
+	rollout, err := call.Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, rollout)
 	}
 
 	displayRollout(out, rollout)
@@ -120,7 +128,7 @@ func rolloutGet(args []string, service *update.Service, out *tabwriter.Writer) i
 	return OK
 }
 
-func setActive(service *update.Service, out *tabwriter.Writer, active bool) int {
+func setActive(ctx context.Context, service *update.Service, out *tabwriter.Writer, active bool) int {
 	if rolloutFlags.appId.Get() == nil ||
 		rolloutFlags.groupId.Get() == nil {
 		return ERROR_USAGE
@@ -130,12 +138,19 @@ func setActive(service *update.Service, out *tabwriter.Writer, active bool) int
 		Active: active,
 	}
 
+	if dryRun("POST", apiURL(service.BasePath, "apps", rolloutFlags.appId.String(), "groups", rolloutFlags.groupId.String(), "rollout", "active"), rolloutActive) {
+		return OK
+	}
 	setCall := service.Group.Rollout.Active.Set(
 		rolloutFlags.appId.String(), rolloutFlags.groupId.String(), rolloutActive)
 
-	rolloutActive, err := setCall.Do()
+	rolloutActive, err := setCall.Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, rolloutActive)
 	}
 
 	if rolloutActive.Active {
@@ -148,12 +163,12 @@ func setActive(service *update.Service, out *tabwriter.Writer, active bool) int
 	return OK
 }
 
-func rolloutActivate(args []string, service *update.Service, out *tabwriter.Writer) int {
-	return setActive(service, out, true)
+func rolloutActivate(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	return setActive(ctx, service, out, true)
 }
 
-func rolloutDeactivate(args []string, service *update.Service, out *tabwriter.Writer) int {
-	return setActive(service, out, false)
+func rolloutDeactivate(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	return setActive(ctx, service, out, false)
 }
 
 func generateLinear(appId, groupId string, frameSize, totalDuration int64) *update.Rollout {
@@ -202,7 +217,7 @@ func generateLinear(appId, groupId string, frameSize, totalDuration int64) *upda
 	}
 }
 
-func rolloutLinear(args []string, service *update.Service, out *tabwriter.Writer) int {
+func rolloutLinear(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if rolloutFlags.appId.Get() == nil ||
 		rolloutFlags.groupId.Get() == nil ||
 		rolloutFlags.frameSize == 0 ||
@@ -213,12 +228,19 @@ func rolloutLinear(args []string, service *update.Service, out *tabwriter.Writer
 	rollout := generateLinear(rolloutFlags.appId.String(), rolloutFlags.groupId.String(),
 		rolloutFlags.frameSize, rolloutFlags.duration)
 
+	if dryRun("POST", apiURL(service.BasePath, "apps", rolloutFlags.appId.String(), "groups", rolloutFlags.groupId.String(), "rollout"), rollout) {
+		return OK
+	}
 	call := service.Group.Rollout.Set(
 		rolloutFlags.appId.String(), rolloutFlags.groupId.String(), rollout)
 
-	rollout, err := call.Do()
+	rollout, err := call.Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, rollout)
 	}
 
 	fmt.Fprintf(out, "rollout set\n")
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// runSummary is the line --summary writes to stderr when a command exits.
+type runSummary struct {
+	Command    string `json:"command"`
+	DurationMs int64  `json:"durationMs"`
+	HTTPCalls  int64  `json:"httpCalls"`
+	Exit       int    `json:"exit"`
+}
+
+var (
+	// summaryCommand and summaryStart are set by main once a command starts
+	// running; nothing is reported for errors before that.
+	summaryCommand string
+	summaryStart   time.Time
+
+	// httpCalls is the number of requests sent, retries included.
+	httpCalls int64
+)
+
+// exitWith exits with code, first closing --out-file and --log-file,
+// exporting the --trace spans and writing the --summary line if one is due.
+// Everything that exits once a command has started goes through it, fatal
+// included, so handlers must not call log.Fatal or os.Exit.
+func exitWith(code int) {
+	if outFile != nil {
+		out.Flush()
+		if err := outFile.Close(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			if code == OK {
+				code = ERROR_API
+			}
+		}
+	}
+	if logFile != nil {
+		logFile.Close()
+	}
+	if tracer != nil {
+		tracer.finish(code)
+	}
+	if globalFlags.Summary && summaryCommand != "" {
+		b, _ := json.Marshal(runSummary{
+			Command:    summaryCommand,
+			DurationMs: time.Since(summaryStart).Nanoseconds() / int64(time.Millisecond),
+			HTTPCalls:  atomic.LoadInt64(&httpCalls),
+			Exit:       code,
+		})
+		fmt.Fprintf(os.Stderr, "%s\n", b)
+	}
+	os.Exit(code)
+}
+
+// countingRoundTripper counts the requests it carries in httpCalls.
+type countingRoundTripper struct {
+	Transport http.RoundTripper
+}
+
+func (t *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&httpCalls, 1)
+	return t.Transport.RoundTrip(req)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// isTerminal reports whether f is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0 && isatty(f.Fd())
+}
+
+// colorOutput reports whether stdout gets ANSI colors. main sets it from
+// --color, after pointing stdout at --out-file.
+var colorOutput bool
+
+func parseColorMode(mode string) (bool, error) {
+	switch mode {
+	case "auto":
+		f, ok := stdout.(*os.File)
+		return ok && isTerminal(f), nil
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid --color %q: expected auto, always or never", mode)
+}
+
+// ANSI sequences used for colored output. The colors have the same length
+// so colored cells of a column line up.
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiYellow  = "\x1b[33m"
+	ansiDefault = "\x1b[39m"
+)
+
+// colorize wraps s in color when colored output is enabled.
+func colorize(color, s string) string {
+	if !colorOutput {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+// colorStatus colors an instance status: errors red, completed updates
+// green and updates in progress yellow.
+func colorStatus(status string) string {
+	switch status {
+	case instanceError:
+		return colorize(ansiRed, status)
+	case instanceComplete:
+		return colorize(ansiGreen, status)
+	case instanceUpdating:
+		return colorize(ansiYellow, status)
+	}
+	return colorize(ansiDefault, status)
+}
+
+// colorLastSeen colors the last seen time of an instance that hasn't been
+// seen for more than staleAfter yellow.
+func colorLastSeen(s string) string {
+	if lastSeenStale(s) {
+		return colorize(ansiYellow, s)
+	}
+	return colorize(ansiDefault, s)
+}
+
+// progressShown is set while showProgress has a line on stderr.
+var progressShown bool
+
+// showProgress replaces the transient progress line on stderr with the
+// formatted message. It does nothing unless stderr is a terminal; --color
+// only affects stdout.
+func showProgress(format string, args ...interface{}) {
+	if !isTerminal(os.Stderr) {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\r"+format+"\x1b[K", args...)
+	progressShown = true
+}
+
+// clearProgress erases the progress line, if any, so that what follows
+// starts on a clean line.
+func clearProgress() {
+	if progressShown {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+		progressShown = false
+	}
+}
+
+// readPassword prompts on stderr and reads a line from stdin with terminal
+// echo disabled.
+func readPassword(prompt string) (string, error) {
+	fd := os.Stdin.Fd()
+	if err := setEcho(fd, false); err != nil {
+		return "", err
+	}
+
+	// make sure echo comes back if we're interrupted at the prompt
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-sig:
+			setEcho(fd, true)
+			fmt.Fprintln(os.Stderr)
+			os.Exit(ERROR_USAGE)
+		case <-done:
+		}
+	}()
+	defer signal.Stop(sig)
+	defer setEcho(fd, true)
+
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// confirmDestructive guards a destructive command. Unless yes (the command's
+// --yes flag) is set, it asks for confirmation on a terminal and refuses when
+// there is none, explaining why on stderr.
+func confirmDestructive(ctx context.Context, yes bool, prompt string) bool {
+	return confirmDestructiveName(ctx, yes, prompt, "")
+}
+
+// confirmDestructiveName is confirmDestructive for deleting the resource
+// called name. With --confirm-by-name the name has to be typed to confirm,
+// rather than answering yes.
+func confirmDestructiveName(ctx context.Context, yes bool, prompt, name string) bool {
+	if yes {
+		return true
+	}
+	if !isTerminal(os.Stdin) {
+		fmt.Fprintln(os.Stderr, "refusing to continue without confirmation; pass --yes to skip it")
+		return false
+	}
+	var confirmed bool
+	if name != "" && globalFlags.ConfirmByName {
+		confirmed = confirmName(ctx, prompt, name)
+	} else {
+		confirmed = confirm(ctx, prompt)
+	}
+	if !confirmed {
+		fmt.Fprintln(os.Stderr, "aborted")
+		return false
+	}
+	return true
+}
+
+// confirm asks a yes/no question on stderr and reports whether the answer
+// read from stdin was yes. It gives up and returns false if ctx is canceled
+// while waiting for an answer.
+func confirm(ctx context.Context, prompt string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", prompt)
+
+	line, ok := readAnswer(ctx)
+	if !ok {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// confirmName asks for name to be typed exactly and reports whether it was.
+func confirmName(ctx context.Context, prompt, name string) bool {
+	fmt.Fprintf(os.Stderr, "%s\nType %s to confirm: ", prompt, name)
+
+	line, ok := readAnswer(ctx)
+	if !ok {
+		return false
+	}
+	if strings.TrimSpace(line) != name {
+		fmt.Fprintln(os.Stderr, "the name typed doesn't match")
+		return false
+	}
+	return true
+}
+
+// readAnswer reads a line from stdin, returning false if ctx is canceled
+// first.
+func readAnswer(ctx context.Context) (string, bool) {
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer <- line
+	}()
+
+	select {
+	case line := <-answer:
+		return line, true
+	case <-ctx.Done():
+		fmt.Fprintln(os.Stderr)
+		return "", false
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package main
+
+import "errors"
+
+func setEcho(fd uintptr, echo bool) error {
+	return errors.New("disabling terminal echo is not supported on this platform")
+}
+
+// isatty can't tell a terminal from other character devices here, so it
+// leaves the decision to isTerminal's file mode check.
+func isatty(fd uintptr) bool {
+	return true
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/updateservicectl/version"
+)
+
+// --trace sends the spans of a run to an OpenTelemetry collector as OTLP
+// over HTTP with JSON encoding, which needs nothing beyond net/http. Without
+// --trace no tracer is created and no transport is added.
+
+const (
+	defaultOTLPEndpoint = "http://localhost:4318"
+	otlpExportTimeout   = 5 * time.Second
+
+	// OTLP span kinds and status codes.
+	spanKindInternal = 1
+	spanKindClient   = 3
+	statusOK         = 1
+	statusError      = 2
+)
+
+// tracer is the trace of the running command, nil unless --trace is given.
+var tracer *commandTracer
+
+// commandTracer collects the root span of a command and the spans of its
+// HTTP requests until the command exits.
+type commandTracer struct {
+	traceID  [16]byte
+	parentID []byte // span of the caller given in TRACEPARENT, if any
+	root     *span
+
+	mu    sync.Mutex
+	spans []*span
+}
+
+type span struct {
+	name     string
+	kind     int
+	id       [8]byte
+	parentID []byte
+	start    time.Time
+	end      time.Time
+	attrs    map[string]interface{}
+	failed   bool
+}
+
+// startTrace creates tracer with a root span for the command name. A W3C
+// traceparent in the TRACEPARENT environment variable, as set by a traced
+// pipeline, makes the command part of that trace.
+func startTrace(name string) {
+	t := &commandTracer{}
+	if id, parent, ok := parseTraceparent(os.Getenv("TRACEPARENT")); ok {
+		t.traceID, t.parentID = id, parent
+	} else {
+		rand.Read(t.traceID[:])
+	}
+	t.root = t.newSpan(cliName+" "+name, spanKindInternal, t.parentID)
+	t.root.attrs["updatectl.command"] = name
+	t.root.attrs["server.address"] = serverHost(servers[0])
+	tracer = t
+}
+
+func (t *commandTracer) newSpan(name string, kind int, parent []byte) *span {
+	s := &span{
+		name:     name,
+		kind:     kind,
+		parentID: parent,
+		start:    time.Now(),
+		attrs:    make(map[string]interface{}),
+	}
+	rand.Read(s.id[:])
+	return s
+}
+
+func (t *commandTracer) record(s *span) {
+	s.end = time.Now()
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+}
+
+// finish ends the root span with the exit code and exports the trace,
+// warning if the collector can't be reached.
+func (t *commandTracer) finish(code int) {
+	t.root.attrs["updatectl.exit_code"] = code
+	t.root.failed = code != OK
+	t.record(t.root)
+	if err := t.export(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: exporting the trace failed (%v)\n", err)
+	}
+}
+
+// traceparent returns the W3C trace context header naming s as the parent.
+func (t *commandTracer) traceparent(s *span) string {
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(t.traceID[:]), hex.EncodeToString(s.id[:]))
+}
+
+// parseTraceparent parses a W3C traceparent header. Version ff and all zero
+// trace or span IDs are invalid, and such headers are ignored.
+func parseTraceparent(h string) (traceID [16]byte, parentID []byte, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return traceID, nil, false
+	}
+	if v, err := hex.DecodeString(parts[0]); err != nil || v[0] == 0xff {
+		return traceID, nil, false
+	}
+	id, err := hex.DecodeString(parts[1])
+	if err != nil || allZero(id) {
+		return traceID, nil, false
+	}
+	parent, err := hex.DecodeString(parts[2])
+	if err != nil || allZero(parent) {
+		return traceID, nil, false
+	}
+	copy(traceID[:], id)
+	return traceID, parent, true
+}
+
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func serverHost(server string) string {
+	if u, err := url.Parse(server); err == nil {
+		return u.Hostname()
+	}
+	return server
+}
+
+// traceRoundTripper records a client span, a child of the command's root
+// span, for each request it carries, and passes the span on to the server
+// in a traceparent header.
+type traceRoundTripper struct {
+	Transport http.RoundTripper
+	Tracer    *commandTracer
+}
+
+func (t *traceRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	s := t.Tracer.newSpan("HTTP "+req.Method, spanKindClient, t.Tracer.root.id[:])
+	s.attrs["http.request.method"] = req.Method
+	s.attrs["server.address"] = req.URL.Hostname()
+	s.attrs["url.path"] = req.URL.Path
+	defer t.Tracer.record(s)
+
+	r := req.Clone(req.Context())
+	r.Header.Set("traceparent", t.Tracer.traceparent(s))
+	resp, err := t.Transport.RoundTrip(r)
+	if err != nil {
+		s.attrs["error.type"] = fmt.Sprintf("%T", err)
+		s.failed = true
+		return nil, err
+	}
+	s.attrs["http.response.status_code"] = resp.StatusCode
+	s.failed = resp.StatusCode >= 400
+	return resp, nil
+}
+
+// otlpEndpoint returns the URL traces are posted to, following the
+// OpenTelemetry exporter environment variables.
+func otlpEndpoint() string {
+	if e := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); e != "" {
+		return e
+	}
+	e := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if e == "" {
+		e = defaultOTLPEndpoint
+	}
+	return strings.TrimRight(e, "/") + "/v1/traces"
+}
+
+func (t *commandTracer) export() error {
+	t.mu.Lock()
+	spans := make([]interface{}, len(t.spans))
+	for i, s := range t.spans {
+		spans[i] = t.otlpSpan(s)
+	}
+	t.mu.Unlock()
+
+	b, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{
+					"service.name":    cliName,
+					"service.version": version.Version,
+				}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": cliName, "version": version.Version},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The export goes straight to the collector, not through the traced
+	// API transport.
+	client := &http.Client{Timeout: otlpExportTimeout}
+	resp, err := client.Post(otlpEndpoint(), "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", otlpEndpoint(), resp.Status)
+	}
+	return nil
+}
+
+func (t *commandTracer) otlpSpan(s *span) map[string]interface{} {
+	status := statusOK
+	if s.failed {
+		status = statusError
+	}
+	v := map[string]interface{}{
+		"traceId":           hex.EncodeToString(t.traceID[:]),
+		"spanId":            hex.EncodeToString(s.id[:]),
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.attrs),
+		"status":            map[string]interface{}{"code": status},
+	}
+	if s.parentID != nil {
+		v["parentSpanId"] = hex.EncodeToString(s.parentID)
+	}
+	return v
+}
+
+// otlpAttributes encodes attrs, whose values are strings or ints, as OTLP
+// key values.
+func otlpAttributes(attrs map[string]interface{}) []interface{} {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var kvs []interface{}
+	for _, k := range keys {
+		var value map[string]interface{}
+		switch v := attrs[k].(type) {
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		kvs = append(kvs, map[string]interface{}{"key": k, "value": value})
+	}
+	return kvs
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		header string
+		ok     bool
+	}{
+		{"00-" + traceID + "-" + spanID + "-01", true},
+		{" 00-" + traceID + "-" + spanID + "-00\n", true},
+		{"01-" + traceID + "-" + spanID + "-01", true},
+		{"", false},
+		{"ff-" + traceID + "-" + spanID + "-01", false},
+		{"0-" + traceID + "-" + spanID + "-01", false},
+		{"zz-" + traceID + "-" + spanID + "-01", false},
+		{"00-00000000000000000000000000000000-" + spanID + "-01", false},
+		{"00-" + traceID + "-0000000000000000-01", false},
+		{"00-" + traceID[1:] + "-" + spanID + "-01", false},
+		{"00-" + traceID + "-" + spanID[1:] + "-01", false},
+		{"00-" + traceID + "-" + "00f067aa0ba902bz" + "-01", false},
+		{"00-" + traceID + "-" + spanID, false},
+	}
+	for _, tt := range tests {
+		id, parent, ok := parseTraceparent(tt.header)
+		if ok != tt.ok {
+			t.Errorf("parseTraceparent(%q) ok = %v, want %v", tt.header, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got := hex.EncodeToString(id[:]); got != traceID {
+			t.Errorf("parseTraceparent(%q) trace ID = %s, want %s", tt.header, got, traceID)
+		}
+		if got := hex.EncodeToString(parent); got != spanID {
+			t.Errorf("parseTraceparent(%q) parent ID = %s, want %s", tt.header, got, spanID)
+		}
+	}
+}
+
+func TestOTLPAttributes(t *testing.T) {
+	got := otlpAttributes(map[string]interface{}{
+		"updatectl.exit_code": 3,
+		"server.address":      "example.com",
+		"http.request.method": "GET",
+	})
+	want := []interface{}{
+		map[string]interface{}{"key": "http.request.method", "value": map[string]interface{}{"stringValue": "GET"}},
+		map[string]interface{}{"key": "server.address", "value": map[string]interface{}{"stringValue": "example.com"}},
+		map[string]interface{}{"key": "updatectl.exit_code", "value": map[string]interface{}{"intValue": "3"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("otlpAttributes = %v, want %v", got, want)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTraceRoundTripper(t *testing.T) {
+	tr := &commandTracer{}
+	tr.root = tr.newSpan("root", spanKindInternal, nil)
+
+	var sent *http.Request
+	rt := &traceRoundTripper{
+		Tracer: tr,
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			sent = req
+			if req.URL.Path == "/fail" {
+				return nil, errors.New("connection refused")
+			}
+			return &http.Response{StatusCode: http.StatusNotFound}, nil
+		}),
+	}
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/apps", nil)
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get("traceparent") != "" {
+		t.Error("RoundTrip modified the caller's request")
+	}
+	if len(tr.spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(tr.spans))
+	}
+	s := tr.spans[0]
+	if got, want := sent.Header.Get("traceparent"), tr.traceparent(s); got != want {
+		t.Errorf("traceparent = %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(s.parentID, tr.root.id[:]) {
+		t.Errorf("span parent = %x, want the root span %x", s.parentID, tr.root.id)
+	}
+	if s.attrs["http.response.status_code"] != http.StatusNotFound || s.attrs["url.path"] != "/api/apps" || !s.failed {
+		t.Errorf("unexpected span %+v", s)
+	}
+
+	req, _ = http.NewRequest("POST", "http://example.com/fail", nil)
+	if _, err := rt.RoundTrip(req); err == nil {
+		t.Fatal("RoundTrip didn't return the transport's error")
+	}
+	if s := tr.spans[1]; !s.failed || s.attrs["error.type"] == nil {
+		t.Errorf("span of a failed request %+v isn't marked failed", s)
+	}
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// idleConnTimeout is how long an idle connection is kept in the pool.
+const idleConnTimeout = 90 * time.Second
+
+// newBaseTransport returns the transport that carries authenticated API
+// requests, configured from the TLS and proxy related global flags. Without
+// --proxy the proxy is taken from the environment.
+func newBaseTransport() (*http.Transport, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: globalFlags.SkipSSLVerify}
+	if globalFlags.CAFile != "" {
+		pem, err := ioutil.ReadFile(globalFlags.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", globalFlags.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if globalFlags.Proxy != "" {
+		u, err := url.Parse(globalFlags.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --proxy %q: %v", globalFlags.Proxy, err)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	// All requests go to the one server, so the per host limit is the one
+	// that matters for reuse.
+	return &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		Proxy:               proxy,
+		MaxIdleConns:        globalFlags.MaxIdleConns,
+		MaxIdleConnsPerHost: globalFlags.MaxIdleConns,
+		IdleConnTimeout:     idleConnTimeout,
+	}, nil
+}
+
+// credentialHeaders are the headers redactRequest blanks out.
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization"}
+
+const redacted = "<redacted>"
+
+// redactRequest returns a shallow copy of req whose credential headers are
+// replaced with a placeholder, safe to write to logs.
+func redactRequest(req *http.Request) *http.Request {
+	r := *req
+	r.Header = req.Header.Clone()
+	for _, h := range credentialHeaders {
+		if r.Header.Get(h) != "" {
+			r.Header.Set(h, redacted)
+		}
+	}
+	return &r
+}
+
+// redactURL returns s with the password of its user info, if any, replaced
+// with a placeholder.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	userinfo := u.User.String() + "@"
+	if !strings.Contains(s, userinfo) {
+		return u.Redacted()
+	}
+	return strings.Replace(s, userinfo, url.PathEscape(u.User.Username())+":"+redacted+"@", 1)
+}
+
+// minRedactedKey is the shortest API key redact looks for. Shorter ones
+// can't be told apart from ordinary text.
+const minRedactedKey = 8
+
+// redact replaces any occurrence of the API key in s, so that messages
+// echoing a request back can be printed safely.
+func redact(s string) string {
+	if len(globalFlags.Key) < minRedactedKey {
+		return s
+	}
+	return strings.Replace(s, globalFlags.Key, redacted, -1)
+}
+
+// debugRoundTripper implements --debug by dumping each request line and its
+// headers, and each response with its body, to Out. Credentials are redacted
+// so debug output can be shared without leaking them.
+type debugRoundTripper struct {
+	Transport http.RoundTripper
+	Out       io.Writer
+}
+
+func (t *debugRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if b, err := httputil.DumpRequestOut(redactRequest(req), false); err == nil {
+		fmt.Fprint(t.Out, redact(string(b)))
+	}
+
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		fmt.Fprintf(t.Out, "error: %s\n\n", redact(err.Error()))
+		return nil, err
+	}
+	if b, err := httputil.DumpResponse(resp, true); err == nil {
+		fmt.Fprintf(t.Out, "%s\n\n", redact(string(b)))
+	}
+	return resp, nil
+}
+
+// apiLogEntry is the line --log-file gets for each request sent.
+type apiLogEntry struct {
+	Time       string `json:"time"`
+	Method     string `json:"method"`
+	URL        string `json:"url"`
+	Status     int    `json:"status,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// logRoundTripper implements --log-file by appending a JSON line for every
+// request to Out. Like debugRoundTripper it sits below the Hawk transport,
+// so retries are logged, and it never logs headers.
+type logRoundTripper struct {
+	Transport http.RoundTripper
+	Out       io.Writer
+
+	mu sync.Mutex // serializes writes from concurrent requests
+}
+
+func (t *logRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.Transport.RoundTrip(req)
+
+	entry := apiLogEntry{
+		Time:       start.UTC().Format(time.RFC3339Nano),
+		Method:     req.Method,
+		URL:        redact(req.URL.String()),
+		DurationMs: time.Since(start).Nanoseconds() / int64(time.Millisecond),
+	}
+	if err != nil {
+		entry.Error = redact(err.Error())
+	} else {
+		entry.Status = resp.StatusCode
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if enc.Encode(entry) == nil {
+		t.mu.Lock()
+		t.Out.Write(buf.Bytes())
+		t.mu.Unlock()
+	}
+	return resp, err
+}
+
+// requestIDHeader is the header the roller identifies each request with in
+// its own logs.
+const requestIDHeader = "X-Request-Id"
+
+// requestIDRoundTripper remembers the request ID of the last error response,
+// so that the message printed for the failure can quote it to support.
+type requestIDRoundTripper struct {
+	Transport http.RoundTripper
+
+	mu     sync.Mutex
+	lastID string
+}
+
+func (t *requestIDRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err == nil && resp.StatusCode >= 400 {
+		if id := resp.Header.Get(requestIDHeader); id != "" {
+			t.mu.Lock()
+			t.lastID = id
+			t.mu.Unlock()
+		}
+	}
+	return resp, err
+}
+
+// LastID returns the request ID of the last error response, if any.
+func (t *requestIDRoundTripper) LastID() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastID
+}
+
+// apiVersionHeader is the header the roller advertises its API version in.
+const apiVersionHeader = "X-Api-Version"
+
+// apiVersionRoundTripper warns once, on Out, when a response advertises a
+// newer API version than Version, the one the client was built against.
+type apiVersionRoundTripper struct {
+	Transport http.RoundTripper
+	Out       io.Writer
+	Version   string
+
+	once sync.Once
+}
+
+func (t *apiVersionRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if server := resp.Header.Get(apiVersionHeader); server != "" && apiVersionNewer(server, t.Version) {
+		t.once.Do(func() {
+			fmt.Fprintf(t.Out, "warning: the server speaks API %s but this client was built for %s and may be out of date\n", server, t.Version)
+		})
+	}
+	return resp, nil
+}
+
+// apiVersionNewer reports whether API version a, such as "v2" or "2.1", is
+// newer than b. Versions that don't parse are never newer.
+func apiVersionNewer(a, b string) bool {
+	x, ok := parseAPIVersion(a)
+	if !ok {
+		return false
+	}
+	y, ok := parseAPIVersion(b)
+	if !ok {
+		return false
+	}
+	for i := 0; i < len(x) || i < len(y); i++ {
+		var m, n int
+		if i < len(x) {
+			m = x[i]
+		}
+		if i < len(y) {
+			n = y[i]
+		}
+		if m != n {
+			return m > n
+		}
+	}
+	return false
+}
+
+func parseAPIVersion(s string) ([]int, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	var parts []int
+	for _, p := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+	return parts, true
+}
+
+// fallbackRoundTripper sends requests for Servers[0] to the other Servers in
+// turn while they fail to connect. The first server that answers is used
+// for the requests that follow. It sits above the Hawk transport, since the
+// signature covers the host.
+type fallbackRoundTripper struct {
+	Transport   http.RoundTripper
+	Servers     []string
+	RetryWrites bool      // also fall back on POST and PATCH requests
+	Debug       io.Writer // if set, told which server served each request
+
+	mu      sync.Mutex
+	current int
+}
+
+func (t *fallbackRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	primary := t.Servers[0]
+	rawURL := req.URL.String()
+	if !strings.HasPrefix(rawURL, primary) {
+		return t.Transport.RoundTrip(req)
+	}
+
+	t.mu.Lock()
+	start := t.current
+	t.mu.Unlock()
+
+	var err error
+	for i := 0; i < len(t.Servers); i++ {
+		n := (start + i) % len(t.Servers)
+		server := t.Servers[n]
+
+		r := req
+		if n != 0 || i > 0 {
+			if r, err = rebaseRequest(req, rawURL, primary, server, i > 0); err != nil {
+				return nil, err
+			}
+		}
+
+		var resp *http.Response
+		resp, err = t.Transport.RoundTrip(r)
+		if err == nil {
+			t.mu.Lock()
+			t.current = n
+			t.mu.Unlock()
+			if t.Debug != nil {
+				fmt.Fprintf(t.Debug, "served by %s\n", server)
+			}
+			return resp, nil
+		}
+
+		if req.Context().Err() != nil || !connectFailed(err) {
+			return nil, err
+		}
+		if !replayable(req) || !(idempotentMethod(req.Method) || t.RetryWrites) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// connectFailed reports whether err means the request never reached the
+// server: its name didn't resolve or the connection was refused. Errors
+// after the request was sent, such as read timeouts, don't count, since the
+// server may have acted on it.
+func connectFailed(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.As(err, new(*net.DNSError))
+}
+
+// rebaseRequest returns a copy of req sent to server instead of primary,
+// with a fresh copy of the body if the original may have been sent already.
+func rebaseRequest(req *http.Request, rawURL, primary, server string, resend bool) (*http.Request, error) {
+	u, err := url.Parse(server + strings.TrimPrefix(rawURL, primary))
+	if err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.URL, r.Host = u, ""
+	if resend && req.GetBody != nil {
+		if r.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// serverFlag is --server. Giving it more than once lists fallback servers,
+// as does separating them with commas.
+type serverFlag struct {
+	value *string
+	given bool
+}
+
+func (f *serverFlag) Set(value string) error {
+	if f.given {
+		*f.value += "," + value
+	} else {
+		*f.value, f.given = value, true
+	}
+	return nil
+}
+
+func (f *serverFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return *f.value
+}
+
+// readOnlyRoundTripper carries the unauthenticated requests of --anonymous.
+// It refuses anything but reads, since the server would reject writes
+// without credentials anyway.
+type readOnlyRoundTripper struct {
+	Transport http.RoundTripper
+}
+
+type readOnlyError struct {
+	Method string
+}
+
+func (e *readOnlyError) Error() string {
+	return fmt.Sprintf("%s requests modify the server and can't be sent with --anonymous; set --user and --key instead", e.Method)
+}
+
+func (t *readOnlyRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return t.Transport.RoundTrip(req)
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, &readOnlyError{Method: req.Method}
+}
+
+// headerFlag collects the "Name: Value" pairs given with --header.
+type headerFlag []string
+
+func (f *headerFlag) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return errors.New(`expected "Name: Value"`)
+	}
+	name := strings.TrimSpace(parts[0])
+	if name == "" || strings.IndexFunc(name, func(r rune) bool {
+		return r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r)
+	}) >= 0 {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	if strings.ContainsAny(parts[1], "\r\n") {
+		return fmt.Errorf("invalid value for header %s", name)
+	}
+	*f = append(*f, name+": "+strings.TrimSpace(parts[1]))
+	return nil
+}
+
+func (f *headerFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+// redacted returns the headers as String does, with the values of
+// credentialHeaders replaced with a placeholder.
+func (f headerFlag) redacted() string {
+	headers := make([]string, len(f))
+	for i, kv := range f {
+		headers[i] = kv
+		name := strings.SplitN(kv, ": ", 2)[0]
+		for _, h := range credentialHeaders {
+			if strings.EqualFold(name, h) {
+				headers[i] = name + ": " + redacted
+			}
+		}
+	}
+	return strings.Join(headers, ", ")
+}
+
+// Header returns the collected headers. Repeating a name adds values.
+func (f headerFlag) Header() http.Header {
+	h := make(http.Header)
+	for _, kv := range f {
+		parts := strings.SplitN(kv, ": ", 2)
+		h.Add(parts[0], parts[1])
+	}
+	return h
+}
+
+// headerRoundTripper adds Header to every request, for proxies in front of
+// the roller that expect headers of their own.
+type headerRoundTripper struct {
+	Transport http.RoundTripper
+	Header    http.Header
+}
+
+func (t *headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range t.Header {
+		req.Header[name] = append(req.Header[name], values...)
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+)
+
+// retryRoundTripper retries requests that fail with a connection error or a
+// 5xx status, sleeping with jittered exponential backoff between attempts.
+// Requests rate limited with a 429 status are retried whatever their method,
+// since the server didn't act on them, after the delay the server asks for
+// in Retry-After. It wraps the authenticating transport so every attempt is
+// signed afresh.
+type retryRoundTripper struct {
+	Transport      http.RoundTripper
+	Retries        int
+	RetryWrites    bool          // also retry POST and PATCH requests
+	AttemptTimeout time.Duration // limit on each attempt, 0 for none
+}
+
+func (t *retryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !replayable(req) {
+		return t.Transport.RoundTrip(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		r := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+
+		resp, err := t.attempt(r)
+		if attempt >= t.Retries || !t.shouldRetry(req, resp, err) {
+			return resp, err
+		}
+		delay := backoff(attempt)
+		if resp != nil {
+			if d, ok := retryAfter(resp, time.Now()); ok {
+				delay = d
+			}
+		}
+		// Give up rather than start an attempt the overall --timeout
+		// would cut short anyway.
+		if deadline, ok := req.Context().Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if err := sleepContext(req, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// attempt sends req once, within AttemptTimeout if it is set. The timeout
+// also covers reading the response body, so it is only released once the
+// body is closed.
+func (t *retryRoundTripper) attempt(req *http.Request) (*http.Response, error) {
+	if t.AttemptTimeout <= 0 {
+		return t.Transport.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.AttemptTimeout)
+	resp, err := t.Transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody cancels the context of the request it is the response body of
+// when closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// replayable reports whether the body of req, if any, can be sent again.
+func replayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// idempotentMethod reports whether sending a request with method twice has
+// the same effect as sending it once.
+func idempotentMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func (t *retryRoundTripper) idempotent(req *http.Request) bool {
+	return idempotentMethod(req.Method) || t.RetryWrites
+}
+
+func (t *retryRoundTripper) shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if !t.idempotent(req) {
+		return false
+	}
+	if err != nil {
+		return !errors.As(err, new(*readOnlyError))
+	}
+	return resp.StatusCode >= 500
+}
+
+// retryAfter returns the delay a 429 response asks for in its Retry-After
+// header, given either in seconds or as an HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// backoff returns a random delay of up to retryBaseDelay * 2^attempt, capped
+// at retryMaxDelay.
+func backoff(attempt int) time.Duration {
+	d := retryBaseDelay << uint(attempt)
+	if d <= 0 || d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// sleepContext waits for d, returning early with an error if the request is
+// canceled in the meantime.
+func sleepContext(req *http.Request, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
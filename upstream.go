@@ -2,9 +2,10 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"text/tabwriter"
 
+	"golang.org/x/net/context"
+
 	"github.com/coreos/updateservicectl/client/update/v1"
 )
 
@@ -57,6 +58,9 @@ var (
 )
 
 func init() {
+	upstreamFlags.url.required = true
+	upstreamFlags.id.required = true
+
 	cmdUpstreamCreate.Flags.Var(&upstreamFlags.url, "url", "The root url of the upstream Update Service.")
 	cmdUpstreamCreate.Flags.Var(&upstreamFlags.label, "label", "The label of the upstream Update Service.")
 
@@ -68,14 +72,14 @@ func init() {
 }
 
 func writeUpstreamHeading(out *tabwriter.Writer) {
-	fmt.Fprintln(out, "Id\tUrl\tLabel")
+	printHeader(out, "Id\tUrl\tLabel\n")
 }
 
 func formatUpstream(us *update.Upstream) string {
 	return fmt.Sprintf("%s\t%s\t%s\n", us.Id, us.Url, us.Label)
 }
 
-func upstreamCreate(args []string, service *update.Service, out *tabwriter.Writer) int {
+func upstreamCreate(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if upstreamFlags.url.Get() == nil {
 		return ERROR_USAGE
 	}
@@ -84,11 +88,18 @@ func upstreamCreate(args []string, service *update.Service, out *tabwriter.Write
 		Url:   upstreamFlags.url.String(),
 		Label: upstreamFlags.label.String(),
 	}
+	if dryRun("POST", apiURL(service.BasePath, "upstream"), req) {
+		return OK
+	}
 	call := service.Upstream.Insert(req)
 
-	upstream, err := call.Do()
+	upstream, err := call.Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, upstream)
 	}
 
 	writeUpstreamHeading(out)
@@ -98,7 +109,7 @@ func upstreamCreate(args []string, service *update.Service, out *tabwriter.Write
 	return OK
 }
 
-func upstreamUpdate(args []string, service *update.Service, out *tabwriter.Writer) int {
+func upstreamUpdate(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if upstreamFlags.url.Get() == nil || upstreamFlags.id.Get() == nil {
 		return ERROR_USAGE
 	}
@@ -108,11 +119,18 @@ func upstreamUpdate(args []string, service *update.Service, out *tabwriter.Write
 		Url:   upstreamFlags.url.String(),
 		Label: upstreamFlags.label.String(),
 	}
+	if dryRun("PUT", apiURL(service.BasePath, "upstream", req.Id), req) {
+		return OK
+	}
 	call := service.Upstream.Update(req.Id, req)
 
-	upstream, err := call.Do()
+	upstream, err := call.Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, upstream)
 	}
 
 	writeUpstreamHeading(out)
@@ -122,15 +140,22 @@ func upstreamUpdate(args []string, service *update.Service, out *tabwriter.Write
 	return OK
 }
 
-func upstreamDelete(args []string, service *update.Service, out *tabwriter.Writer) int {
+func upstreamDelete(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	if upstreamFlags.id.Get() == nil {
 		return ERROR_USAGE
 	}
 
+	if dryRun("DELETE", apiURL(service.BasePath, "upstream", upstreamFlags.id.String()), nil) {
+		return OK
+	}
 	call := service.Upstream.Delete(upstreamFlags.id.String())
-	upstream, err := call.Do()
+	upstream, err := call.Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, upstream)
 	}
 
 	writeUpstreamHeading(out)
@@ -140,11 +165,19 @@ func upstreamDelete(args []string, service *update.Service, out *tabwriter.Write
 	return OK
 }
 
-func upstreamList(args []string, service *update.Service, out *tabwriter.Writer) int {
+func upstreamList(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
 	call := service.Upstream.List()
-	upstreams, err := call.Do()
+	upstreams, err := call.Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, upstreams.Items)
+	}
+
+	if globalFlags.Quiet {
+		return printIDs(out, upstreams.Items, "Id")
 	}
 
 	writeUpstreamHeading(out)
@@ -156,11 +189,18 @@ func upstreamList(args []string, service *update.Service, out *tabwriter.Writer)
 	return OK
 }
 
-func upstreamSync(args []string, service *update.Service, out *tabwriter.Writer) int {
+func upstreamSync(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if dryRun("POST", apiURL(service.BasePath, "upstream", "sync"), nil) {
+		return OK
+	}
 	call := service.Upstream.Sync()
-	resp, err := call.Do()
+	resp, err := call.Context(ctx).Do()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+
+	if structuredOutput() {
+		return printStructured(out, resp)
 	}
 
 	fmt.Fprintf(out, "Status: %s\n", resp.Status)
@@ -0,0 +1,10 @@
+package version
+
+// GitCommit and BuildDate describe the build. They are empty unless set at
+// link time, as the Makefile does:
+//
+//	go build -ldflags "-X github.com/coreos/updateservicectl/version.GitCommit=..."
+var (
+	GitCommit string
+	BuildDate string
+)
@@ -1,3 +1,7 @@
 package version
 
 const Version = "2.1.0+git"
+
+// APIVersion is the version of the update service API the client is
+// generated from.
+const APIVersion = "v1"
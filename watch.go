@@ -2,9 +2,10 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"encoding/xml"
+	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -12,42 +13,113 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"text/tabwriter"
 	"time"
 
 	"github.com/coreos/go-omaha/omaha"
 	"github.com/pborman/uuid"
+	"golang.org/x/net/context"
 
 	"github.com/coreos/updateservicectl/client/update/v1"
 )
 
 var (
 	watchFlags struct {
-		interval int
-		version  string
-		appId    StringFlag
-		groupId  StringFlag
-		clientId string
+		interval  intervalFlag
+		version   string
+		appId     UUIDFlag
+		groupId   StringFlag
+		clientId  string
+		once      bool
+		instances bool
+		target    string
 	}
 	cmdWatch = &Command{
 		Name:    "watch",
 		Usage:   "[OPTION]... <cmd> <args>",
 		Summary: `Watch for app versions and exec a given command.`,
+		Description: `Polls the roller as an update client would and runs the given command when a new
+version is available. With --instances, instead prints the group's instances on
+every poll, marking those whose version or status changed since the previous one.
+
+With --output json, each poll is written as a single line JSON object carrying
+its time and result, so the output can be consumed as a stream. The command is
+then optional.
+
+With --output prometheus, each poll counts the group's instances and writes
+them as metrics in the Prometheus text format, replacing the contents of
+--out-file so node_exporter's textfile collector can read it:
+
+	roller_instances_total{app_id, group_id, version, status}
+		instances of the group reporting a version, by status
+	roller_watch_last_poll_timestamp_seconds{app_id, group_id}
+		time of the last successful poll`,
 		Run:     watch,
+		NoAuth:  true,
+		AuthFor: watchNeedsAuth,
+		Subcommands: []*Command{
+			cmdWatchGroup,
+		},
+	}
+
+	cmdWatchGroup = &Command{
+		Name:    "watch group",
+		Usage:   "[OPTION]...",
+		Summary: `Show the progress of a group's rollout until interrupted.`,
+		Description: `Counts the group's instances on every poll. On a terminal a progress bar shows
+the share that has completed the update to the target version, with counts by
+status below it. Otherwise a summary line is printed per poll.`,
+		Run: watchGroup,
 	}
 )
 
 func init() {
-	cmdWatch.Flags.IntVar(&watchFlags.interval, "interval", 1, "Update polling interval")
+	watchFlags.appId.required = true
+	watchFlags.groupId.required = true
+	watchFlags.interval = intervalFlag(5 * time.Second)
+	cmdWatch.Flags.Var(&watchFlags.interval, "interval", "Update polling interval, as a duration such as 30s or a number of seconds. At least 1s.")
 	cmdWatch.Flags.StringVar(&watchFlags.version, "version", "0.0.0", "Starting version number")
 	cmdWatch.Flags.Var(&watchFlags.appId, "app-id", "Application to watch.")
 	cmdWatch.Flags.Var(&watchFlags.groupId, "group-id", "Group of application to subscribe to.")
+	cmdWatch.Flags.BoolVar(&watchFlags.once, "once", false, "Check for an update once and exit instead of polling. The command is optional; without one the result is printed.")
+	cmdWatch.Flags.BoolVar(&watchFlags.instances, "instances", false, "Print the group's instances on every poll instead of running a command.")
 	cmdWatch.Flags.StringVar(&watchFlags.clientId, "client-id", "", "Client id to report ad. If not provided a random UUID will be generated.")
+
+	cmdWatchGroup.Flags.Var(&watchFlags.interval, "interval", "Polling interval, as a duration such as 30s or a number of seconds. At least 1s.")
+	cmdWatchGroup.Flags.Var(&watchFlags.appId, "app-id", "Application containing the group.")
+	cmdWatchGroup.Flags.Var(&watchFlags.groupId, "group-id", "Group to watch.")
+	cmdWatchGroup.Flags.StringVar(&watchFlags.target, "version", "", "Version being rolled out. Defaults to the version of the group's channel.")
+}
+
+// minWatchInterval is the shortest polling interval watch allows, to keep
+// from hammering the server.
+const minWatchInterval = time.Second
+
+// intervalFlag is a time.Duration flag that also accepts a bare number of
+// seconds, which is what --interval used to take.
+type intervalFlag time.Duration
+
+func (f *intervalFlag) Set(value string) error {
+	if n, err := strconv.Atoi(value); err == nil {
+		*f = intervalFlag(time.Duration(n) * time.Second)
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*f = intervalFlag(d)
+	return nil
 }
 
-func fetchUpdateCheck(server string, appID string, groupID string, clientID string, version string, debug bool) (*omaha.UpdateCheck, error) {
-	client := &http.Client{}
+func (f *intervalFlag) String() string {
+	return time.Duration(*f).String()
+}
 
+func fetchUpdateCheck(ctx context.Context, server string, appID string, groupID string, clientID string, version string) (*omaha.UpdateCheck, error) {
 	// TODO: Fill out the OS field correctly based on /etc/os-release
 	request := omaha.NewRequest("lsb", "CoreOS", "", "")
 	app := request.AddApp(fmt.Sprintf("{%s}", appID), version)
@@ -66,30 +138,29 @@ func fetchUpdateCheck(server string, appID string, groupID string, clientID stri
 		return nil, err
 	}
 
-	if debug {
-		fmt.Fprintf(os.Stderr, "Request: %s%s\n", xml.Header, raw)
-	}
-
 	u, err := url.Parse(server)
 	if err != nil {
 		return nil, err
 	}
 	u.Path = path.Join(u.Path, "/v1/update")
 
-	resp, err := client.Post(u.String(), "text/xml", bytes.NewReader(raw))
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(raw))
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
 		return nil, err
 	}
+	req.Header.Set("Content-Type", "text/xml")
 
-	body, err := ioutil.ReadAll(resp.Body)
+	resp, err := plainClient.Do(req.WithContext(ctx))
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, redact(errorMessage(err)))
 		return nil, err
 	}
+	defer resp.Body.Close()
 
-	if debug {
-		fmt.Fprintf(os.Stderr, "Response: %s%s\n", xml.Header, string(body))
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, err
 	}
 
 	oresp := &omaha.Response{}
@@ -114,7 +185,7 @@ func prepareEnvironment(appID string, version string, oldVersion string, updateC
 		url, err := url.Parse(updateCheck.Urls.Urls[0].CodeBase)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, err.Error())
-			os.Exit(1)
+			exitWith(1)
 		}
 
 		url.Path = path.Join(url.Path, updateCheck.Manifest.Packages.Packages[0].Name)
@@ -127,37 +198,147 @@ func runCmd(cmdName string, args []string, appID string, version string, oldVers
 	cmd := exec.Command(cmdName, args...)
 	cmd.Env = prepareEnvironment(appID, version, oldVersion, updateCheck)
 
-	stdout, err := cmd.StdoutPipe()
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Start()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, err.Error())
-		os.Exit(1)
+		exitWith(1)
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
-		os.Exit(1)
+	cmd.Wait()
+}
+
+// waitFlags are shared by the commands that can wait for a rollout.
+var waitFlags struct {
+	wait      bool
+	timeout   time.Duration
+	threshold float64
+}
+
+// waitInterval is how often --wait counts the updated instances.
+const waitInterval = 10 * time.Second
+
+func addWaitFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&waitFlags.wait, "wait", false, "Wait until the version has rolled out before exiting.")
+	fs.DurationVar(&waitFlags.timeout, "wait-timeout", 30*time.Minute, "How long --wait waits before giving up with an error.")
+	fs.Float64Var(&waitFlags.threshold, "wait-threshold", 100, "Percentage of instances that must be updated for --wait to finish.")
+}
+
+// checkWaitFlags validates the --wait flags, so a bad value is reported
+// before the command changes anything.
+func checkWaitFlags() bool {
+	if waitFlags.wait && (waitFlags.threshold <= 0 || waitFlags.threshold > 100 || waitFlags.timeout <= 0) {
+		fmt.Fprintln(os.Stderr, "--wait-threshold must be in (0, 100] and --wait-timeout positive")
+		return false
 	}
-	err = cmd.Start()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
-		os.Exit(1)
+	return true
+}
+
+// waitForRollout polls the instances of the given groups until in each of
+// them --wait-threshold percent have completed an update to version, or
+// --wait-timeout passes. Groups without instances are not waited for.
+func waitForRollout(ctx context.Context, service *update.Service, appId string, groupIds []string, version string) int {
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, waitFlags.timeout)
+	defer cancel()
+	tick := time.NewTicker(waitInterval)
+	defer tick.Stop()
+
+	updated := func(cl *update.ClientUpdate) string {
+		if cl.Version == version && instanceStatus(cl) == instanceComplete {
+			return "updated"
+		}
+		return ""
 	}
-	go io.Copy(os.Stdout, stdout)
-	go io.Copy(os.Stderr, stderr)
-	cmd.Wait()
+
+	pending := groupIds
+	for len(pending) > 0 {
+		var waiting []string
+		for i, groupId := range pending {
+			counts, total, err := countInstances(ctx, service, appId, groupId, updated)
+			if err != nil {
+				if ctx.Err() != nil {
+					waiting = append(waiting, pending[i:]...)
+					break
+				}
+				log.Printf("warning: counting instances failed (%v)\n", err)
+				waiting = append(waiting, groupId)
+				continue
+			}
+
+			// a group without instances has nothing left to update
+			if total == 0 {
+				clearProgress()
+				fmt.Fprintf(os.Stderr, "warning: group %s has no instances, not waiting for it\n", groupId)
+				continue
+			}
+			percent := 100 * float64(counts["updated"]) / float64(total)
+			if percent >= waitFlags.threshold {
+				clearProgress()
+				fmt.Fprintf(os.Stderr, "group %s: %.1f%% of %d instances updated to %s\n", groupId, percent, total, version)
+				continue
+			}
+			waiting = append(waiting, groupId)
+			showProgress("waiting for group %s: %.1f%% of %d instances updated to %s...", groupId, percent, total, version)
+		}
+		pending = waiting
+		if len(pending) == 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			clearProgress()
+			if parent.Err() != nil {
+				return ERROR_INTERRUPTED
+			}
+			what := "group "
+			if len(pending) > 1 {
+				what = "groups "
+			}
+			fmt.Fprintf(os.Stderr, "timed out after %v waiting for %s%s to reach %v%% on %s\n", waitFlags.timeout, what, strings.Join(pending, ", "), waitFlags.threshold, version)
+			return ERROR_TIMEOUT
+		case <-tick.C:
+		}
+	}
+	return OK
 }
 
-func watch(args []string, service *update.Service, out *tabwriter.Writer) int {
-	tick := time.NewTicker(time.Second * time.Duration(watchFlags.interval))
+// watchNeedsAuth reports whether watch lists instances, which update checks
+// alone don't need credentials for.
+func watchNeedsAuth() bool {
+	return watchFlags.instances || globalFlags.Output == string(outputPrometheus)
+}
+
+func watch(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	interval := time.Duration(watchFlags.interval)
+	if interval < minWatchInterval {
+		fmt.Fprintf(os.Stderr, "warning: --interval %v is too short, using %v\n", interval, minWatchInterval)
+		interval = minWatchInterval
+	}
+	tick := time.NewTicker(interval)
 	server := globalFlags.Server
-	debug := globalFlags.Debug
 	version := watchFlags.version
 
 	if watchFlags.appId.Get() == nil || watchFlags.groupId.Get() == nil {
 		return ERROR_USAGE
 	}
+	if structuredOutput() && output != outputJSON && output != outputPrometheus {
+		fmt.Fprintln(os.Stderr, "watch supports only table, json and prometheus output")
+		return ERROR_USAGE
+	}
 
-	if len(args) == 0 {
+	if output == outputPrometheus {
+		defer tick.Stop()
+		return watchPrometheus(ctx, service, out, tick.C)
+	}
+
+	if watchFlags.instances {
+		defer tick.Stop()
+		return watchInstances(ctx, service, out, tick.C)
+	}
+
+	if len(args) == 0 && !watchFlags.once && output != outputJSON {
 		return ERROR_USAGE
 	}
 
@@ -170,22 +351,48 @@ func watch(args []string, service *update.Service, out *tabwriter.Writer) int {
 	}
 
 	// initial check
-	updateCheck, err := fetchUpdateCheck(server, appId, groupId, clientId, version, debug)
+	updateCheck, err := fetchUpdateCheck(ctx, server, appId, groupId, clientId, version)
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
-		os.Exit(1)
+		// fetchUpdateCheck has reported the error already
+		return exitCode(err)
 	}
 
-	if updateCheck.Status != "noupdate" && updateCheck.Status != "error-version" {
+	if output == outputJSON && len(args) == 0 {
+		writeJSONLine(out, newWatchCheck(updateCheck, nil))
+		if watchFlags.once {
+			return OK
+		}
+	} else if watchFlags.once && len(args) == 0 {
+		newVersion := ""
+		if updateCheck.Manifest != nil {
+			newVersion = updateCheck.Manifest.Version
+		}
+		printHeader(out, "Status\tVersion\n")
+		fmt.Fprintf(out, "%s\t%s\n", updateCheck.Status, newVersion)
+		out.Flush()
+		return OK
+	}
+
+	if len(args) > 0 && updateCheck.Status != "noupdate" && updateCheck.Status != "error-version" {
 		runCmd(args[0], args[1:], appId, version, "", updateCheck)
 	}
 
+	if watchFlags.once {
+		return OK
+	}
+
 	for {
 		select {
+		case <-ctx.Done():
+			return ERROR_INTERRUPTED
 		case <-tick.C:
 
-			updateCheck, err := fetchUpdateCheck(server, appId, groupId, clientId, version, debug)
+			updateCheck, err := fetchUpdateCheck(ctx, server, appId, groupId, clientId, version)
+			if len(args) == 0 {
+				writeJSONLine(out, newWatchCheck(updateCheck, err))
+				continue
+			}
 			if err != nil {
 				log.Printf("warning: update check failed (%v)\n", err)
 				continue
@@ -209,3 +416,281 @@ func watch(args []string, service *update.Service, out *tabwriter.Writer) int {
 	tick.Stop()
 	return OK
 }
+
+// watchCheck is one poll of watch in JSON output.
+type watchCheck struct {
+	Time    string `json:"time"`
+	Status  string `json:"status,omitempty"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func newWatchCheck(updateCheck *omaha.UpdateCheck, err error) watchCheck {
+	c := watchCheck{Time: time.Now().UTC().Format(time.RFC3339)}
+	if err != nil {
+		c.Error = err.Error()
+		return c
+	}
+	c.Status = updateCheck.Status
+	if updateCheck.Manifest != nil {
+		c.Version = updateCheck.Manifest.Version
+	}
+	return c
+}
+
+// watchSnapshot is one poll of watch --instances in JSON output. Changed
+// lists the client IDs that would be marked in table output.
+type watchSnapshot struct {
+	Time      string                 `json:"time"`
+	Instances []*update.ClientUpdate `json:"instances"`
+	Changed   []string               `json:"changed"`
+}
+
+// writeJSONLine writes v as a single line of JSON, flushing it at once so
+// stream consumers see every poll as it happens.
+func writeJSONLine(out *tabwriter.Writer, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(out, "%s\n", b)
+	out.Flush()
+}
+
+// watchInstances implements watch --instances. Each poll prints the group's
+// instances, marking those that are new or whose version or status differs
+// from the previous poll: in bold with a * when output is colored, otherwise
+// with a CHANGED prefix.
+func watchInstances(ctx context.Context, service *update.Service, out *tabwriter.Writer, tick <-chan time.Time) int {
+	// On a terminal every marker is padded to the same byte length so the
+	// escape sequences don't throw off tabwriter's column widths.
+	changedMark, unchangedMark, end := "CHANGED", "", ""
+	statusHeader := "Status"
+	if colorOutput {
+		changedMark, unchangedMark, end = ansiBold+"*", ansiReset+" ", ansiReset
+		statusHeader = colorize(ansiDefault, statusHeader)
+	}
+
+	var prev map[string]*update.ClientUpdate
+	for {
+		call := service.Clientupdate.List()
+		call.AppId(watchFlags.appId.String())
+		call.GroupId(watchFlags.groupId.String())
+		list, err := call.Context(ctx).Do()
+		if err != nil {
+			if ctx.Err() != nil || watchFlags.once {
+				fatal(err)
+			}
+			log.Printf("warning: listing instances failed (%v)\n", err)
+		} else {
+			cur := make(map[string]*update.ClientUpdate, len(list.Items))
+			snapshot := watchSnapshot{
+				Time:      time.Now().UTC().Format(time.RFC3339),
+				Instances: list.Items,
+				Changed:   []string{},
+			}
+			if snapshot.Instances == nil {
+				snapshot.Instances = []*update.ClientUpdate{}
+			}
+			if output != outputJSON {
+				printHeader(out, "\t"+strings.TrimSuffix(instanceHeader, "\n")+"\t"+statusHeader+"\n")
+			}
+			for _, cl := range list.Items {
+				cur[cl.ClientId] = cl
+
+				mark := unchangedMark
+				if p, ok := prev[cl.ClientId]; prev != nil && (!ok || p.Version != cl.Version || instanceStatus(p) != instanceStatus(cl)) {
+					mark = changedMark
+					snapshot.Changed = append(snapshot.Changed, cl.ClientId)
+				}
+				if output != outputJSON {
+					fmt.Fprintf(out, "%s\t%s\t%s%s\n", mark, strings.Join(instanceFields(cl), "\t"), colorStatus(instanceStatus(cl)), end)
+				}
+			}
+			if output == outputJSON {
+				writeJSONLine(out, snapshot)
+			} else {
+				fmt.Fprintln(out)
+				out.Flush()
+			}
+			prev = cur
+		}
+
+		if watchFlags.once {
+			return OK
+		}
+
+		select {
+		case <-ctx.Done():
+			return ERROR_INTERRUPTED
+		case <-tick:
+		}
+	}
+}
+
+// progressBarWidth is the number of cells in the watch group progress bar.
+const progressBarWidth = 40
+
+// watchGroup implements watch group.
+func watchGroup(ctx context.Context, args []string, service *update.Service, out *tabwriter.Writer) int {
+	if watchFlags.appId.Get() == nil || watchFlags.groupId.Get() == nil {
+		return ERROR_USAGE
+	}
+	interval := time.Duration(watchFlags.interval)
+	if interval < minWatchInterval {
+		fmt.Fprintf(os.Stderr, "warning: --interval %v is too short, using %v\n", interval, minWatchInterval)
+		interval = minWatchInterval
+	}
+	appId, groupId := watchFlags.appId.String(), watchFlags.groupId.String()
+
+	target := watchFlags.target
+	if target == "" {
+		group, err := service.Group.Get(appId, groupId).Context(ctx).Do()
+		if err != nil {
+			fatal(err)
+		}
+		channel, code := findChannel(ctx, service, appId, group.ChannelId)
+		if channel == nil {
+			return code
+		}
+		target = channel.Version
+	}
+
+	f, ok := stdout.(*os.File)
+	live := ok && isTerminal(f)
+
+	// Instances that finished updating to target are counted apart from
+	// the other complete ones.
+	const updated = "updated"
+	status := func(cl *update.ClientUpdate) string {
+		s := instanceStatus(cl)
+		if s == instanceComplete && cl.Version == target {
+			return updated
+		}
+		return s
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	drawn := false
+	for {
+		counts, total, err := countInstances(ctx, service, appId, groupId, status)
+		if err != nil {
+			if ctx.Err() != nil {
+				fatal(err)
+			}
+			log.Printf("warning: counting instances failed (%v)\n", err)
+		} else {
+			percent := 0.0
+			if total > 0 {
+				percent = 100 * float64(counts[updated]) / float64(total)
+			}
+			complete := counts[instanceComplete] + counts[updated]
+			statuses := fmt.Sprintf("%s %d  %s %d  %s %d",
+				colorStatus(instanceComplete), complete,
+				colorStatus(instanceUpdating), counts[instanceUpdating],
+				colorStatus(instanceError), counts[instanceError])
+
+			if live {
+				if drawn {
+					// back over the bar and status lines
+					fmt.Fprint(out, "\x1b[2A")
+				}
+				fmt.Fprintf(out, "\r%s %5.1f%% of %d instances at %s\x1b[K\n", progressBar(percent), percent, total, target)
+				fmt.Fprintf(out, "\r%s\x1b[K\n", statuses)
+				drawn = true
+			} else {
+				fmt.Fprintf(out, "%s %.1f%% of %d instances at %s, %s\n", time.Now().UTC().Format(time.RFC3339), percent, total, target, statuses)
+			}
+			out.Flush()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ERROR_INTERRUPTED
+		case <-tick.C:
+		}
+	}
+}
+
+// progressBar draws percent as a bar of progressBarWidth cells.
+func progressBar(percent float64) string {
+	filled := int(percent / 100 * progressBarWidth)
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled) + "]"
+}
+
+// watchPrometheus implements watch --output prometheus.
+func watchPrometheus(ctx context.Context, service *update.Service, out *tabwriter.Writer, tick <-chan time.Time) int {
+	appId, groupId := watchFlags.appId.String(), watchFlags.groupId.String()
+	key := func(cl *update.ClientUpdate) string {
+		return cl.Version + "\x00" + instanceStatus(cl)
+	}
+
+	for {
+		counts, _, err := countInstances(ctx, service, appId, groupId, key)
+		if err != nil {
+			if ctx.Err() != nil || watchFlags.once {
+				fatal(err)
+			}
+			log.Printf("warning: counting instances failed (%v)\n", err)
+		} else if err := writeMetrics(out, prometheusMetrics(appId, groupId, counts, time.Now())); err != nil {
+			log.Printf("warning: writing metrics failed (%v)\n", err)
+		}
+
+		if watchFlags.once {
+			return OK
+		}
+
+		select {
+		case <-ctx.Done():
+			return ERROR_INTERRUPTED
+		case <-tick:
+		}
+	}
+}
+
+// prometheusMetrics formats instance counts keyed by version and status,
+// separated by a NUL, as the metrics watch --output prometheus documents.
+func prometheusMetrics(appId, groupId string, counts map[string]int, now time.Time) []byte {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	group := fmt.Sprintf(`app_id="%s",group_id="%s"`, promLabel(appId), promLabel(groupId))
+	var b bytes.Buffer
+	fmt.Fprintln(&b, "# HELP roller_instances_total Instances of the group reporting a version, by status.")
+	fmt.Fprintln(&b, "# TYPE roller_instances_total gauge")
+	for _, k := range keys {
+		parts := strings.SplitN(k, "\x00", 2)
+		fmt.Fprintf(&b, "roller_instances_total{%s,version=\"%s\",status=\"%s\"} %d\n", group, promLabel(parts[0]), promLabel(parts[1]), counts[k])
+	}
+	fmt.Fprintln(&b, "# HELP roller_watch_last_poll_timestamp_seconds Time of the last successful poll.")
+	fmt.Fprintln(&b, "# TYPE roller_watch_last_poll_timestamp_seconds gauge")
+	fmt.Fprintf(&b, "roller_watch_last_poll_timestamp_seconds{%s} %d\n", group, now.Unix())
+	return b.Bytes()
+}
+
+// promLabel escapes a label value for the Prometheus text format.
+func promLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// writeMetrics replaces the contents of --out-file with metrics, through a
+// rename so the textfile collector never reads a partial file. Without
+// --out-file the metrics are written to stdout.
+func writeMetrics(out *tabwriter.Writer, metrics []byte) error {
+	if globalFlags.OutFile == "" {
+		out.Write(metrics)
+		return out.Flush()
+	}
+	tmp := globalFlags.OutFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, metrics, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, globalFlags.OutFile)
+}
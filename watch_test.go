@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const prometheusGolden = `# HELP roller_instances_total Instances of the group reporting a version, by status.
+# TYPE roller_instances_total gauge
+roller_instances_total{app_id="e96281a6-d1af-4bde-9a0a-97b76e56dc57",group_id="a \"quoted\\\" group\nx",version="1.2.2",status="error"} 1
+roller_instances_total{app_id="e96281a6-d1af-4bde-9a0a-97b76e56dc57",group_id="a \"quoted\\\" group\nx",version="1.2.3",status="complete"} 5
+roller_instances_total{app_id="e96281a6-d1af-4bde-9a0a-97b76e56dc57",group_id="a \"quoted\\\" group\nx",version="1.2.3",status="updating"} 2
+# HELP roller_watch_last_poll_timestamp_seconds Time of the last successful poll.
+# TYPE roller_watch_last_poll_timestamp_seconds gauge
+roller_watch_last_poll_timestamp_seconds{app_id="e96281a6-d1af-4bde-9a0a-97b76e56dc57",group_id="a \"quoted\\\" group\nx"} 1791964800
+`
+
+func TestPrometheusMetrics(t *testing.T) {
+	counts := map[string]int{
+		"1.2.3\x00updating": 2,
+		"1.2.3\x00complete": 5,
+		"1.2.2\x00error":    1,
+	}
+	now := time.Unix(1791964800, 0)
+
+	got := string(prometheusMetrics("e96281a6-d1af-4bde-9a0a-97b76e56dc57", "a \"quoted\\\" group\nx", counts, now))
+	if got != prometheusGolden {
+		t.Errorf("got metrics\n%s\nwant\n%s", got, prometheusGolden)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The update client types only know how to marshal themselves to JSON, so
+// YAML output is produced by re-encoding their JSON form. Field order is
+// preserved by decoding objects into yamlMap rather than a Go map.
+
+type yamlMapItem struct {
+	key   string
+	value interface{}
+}
+
+type yamlMap []yamlMapItem
+
+var (
+	yamlPlainRe    = regexp.MustCompile(`^[A-Za-z0-9_./][A-Za-z0-9_ ./:,@+=()-]*$`)
+	yamlDateRe     = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}`)
+	yamlReservedRe = regexp.MustCompile(`^(?i:y|n|yes|no|on|off|true|false|null|~|\.inf|\.nan)$`)
+)
+
+// marshalYAML encodes v, which must be JSON serializable, as a YAML document.
+func marshalYAML(v interface{}) ([]byte, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	node, err := decodeOrdered(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writeYAML(&buf, node, 0)
+	return buf.Bytes(), nil
+}
+
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		m := yamlMap{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, yamlMapItem{key.(string), value})
+		}
+		_, err = dec.Token()
+		return m, err
+	case json.Delim('['):
+		l := []interface{}{}
+		for dec.More() {
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, value)
+		}
+		_, err = dec.Token()
+		return l, err
+	}
+	return tok, nil
+}
+
+// writeYAML writes v as a block node. Continuation lines are indented by
+// indent spaces; the first line is not, since the caller has already
+// positioned it after a key or sequence marker.
+func writeYAML(w io.Writer, v interface{}, indent int) {
+	pad := strings.Repeat(" ", indent)
+
+	switch v := v.(type) {
+	case yamlMap:
+		if len(v) == 0 {
+			fmt.Fprintln(w, "{}")
+			return
+		}
+		for i, item := range v {
+			if i > 0 {
+				fmt.Fprint(w, pad)
+			}
+			fmt.Fprintf(w, "%s:", yamlScalar(item.key))
+			if isYAMLBlock(item.value) {
+				fmt.Fprintf(w, "\n%s  ", pad)
+				writeYAML(w, item.value, indent+2)
+			} else {
+				fmt.Fprint(w, " ")
+				writeYAML(w, item.value, indent+2)
+			}
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			fmt.Fprintln(w, "[]")
+			return
+		}
+		for i, item := range v {
+			if i > 0 {
+				fmt.Fprint(w, pad)
+			}
+			fmt.Fprint(w, "- ")
+			writeYAML(w, item, indent+2)
+		}
+	default:
+		fmt.Fprintln(w, yamlScalar(v))
+	}
+}
+
+func isYAMLBlock(v interface{}) bool {
+	switch v := v.(type) {
+	case yamlMap:
+		return len(v) > 0
+	case []interface{}:
+		return len(v) > 0
+	}
+	return false
+}
+
+func yamlScalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		if yamlPlain(v) {
+			return v
+		}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.Encode(v)
+		return strings.TrimSuffix(buf.String(), "\n")
+	}
+	return fmt.Sprint(v)
+}
+
+// yamlPlain reports whether s can be written unquoted without being read
+// back as something other than the same string.
+func yamlPlain(s string) bool {
+	if !yamlPlainRe.MatchString(s) || yamlReservedRe.MatchString(s) {
+		return false
+	}
+	if strings.HasSuffix(s, " ") || strings.HasSuffix(s, ":") || strings.Contains(s, ": ") {
+		return false
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return false
+	}
+	if _, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return false
+	}
+	return !yamlDateRe.MatchString(s)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+func TestMarshalYAML(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{
+			in: &update.App{
+				Id:          "e96281a6-d1af-4bde-9a0a-97b76e56dc57",
+				Label:       "CoreOS",
+				Description: "Linux: for servers",
+			},
+			want: `description: "Linux: for servers"
+id: e96281a6-d1af-4bde-9a0a-97b76e56dc57
+label: CoreOS
+`,
+		},
+		{
+			in: []*update.Group{
+				&update.Group{Id: "stable", UpdatePercent: 50, UpdatesPaused: true},
+				&update.Group{Id: "true"},
+			},
+			want: `- id: stable
+  updatePercent: 50
+  updatesPaused: true
+- id: "true"
+`,
+		},
+		{
+			in: &update.Rollout{
+				GroupId: "beta",
+				Rollout: []*update.Frame{&update.Frame{Duration: 60, Percent: 10}},
+			},
+			want: `groupId: beta
+rollout:
+  - duration: "60"
+    percent: 10
+`,
+		},
+		{
+			in:   []string{},
+			want: "[]\n",
+		},
+	}
+
+	for i, tt := range tests {
+		got, err := marshalYAML(tt.in)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("case %d: expected:\n%s\ngot:\n%s", i, tt.want, got)
+		}
+	}
+}